@@ -0,0 +1,553 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+)
+
+func init() {
+	factories.Registry("archive", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil {
+			ctx.Var.FormatPathPtr(&cfg.Path)
+			if cfg.Endpoint != nil {
+				ctx.Var.FormatPtr(&cfg.Endpoint.Path, &cfg.Endpoint.Host)
+			}
+		}
+		return Open(&cfg)
+	}))
+}
+
+// Archive formats
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+type Config struct {
+	// Path of the archive on local disk, used by Open.
+	Path string
+	// FormatZip, FormatTar or FormatTarGz. Empty detects it from the content.
+	Format   string
+	Endpoint *oss.Endpoint
+}
+
+type entry struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	zip     *zip.File
+	archive io.ReaderAt
+	// offset of the content in the tar stream
+	offset int64
+	// size of the tar.gz archive, zero for a plain tar
+	gzSize int64
+}
+
+// open returns the content of the entry. Tar and stored zip entries are read
+// in place, compressed ones are decompressed as a stream.
+func (e *entry) open() (io.ReadSeeker, error) {
+	if e.zip != nil {
+		if e.zip.Method == zip.Store {
+			if offset, err := e.zip.DataOffset(); err == nil {
+				return io.NewSectionReader(e.archive, offset, e.size), nil
+			}
+		}
+		return &streamReader{open: e.zip.Open, size: e.size, checksum: true}, nil
+	}
+	if e.gzSize == 0 {
+		return io.NewSectionReader(e.archive, e.offset, e.size), nil
+	}
+	// gzip can't seek, the archive is decompressed up to the entry
+	return &streamReader{open: func() (io.ReadCloser, error) {
+		gz, err := gzip.NewReader(io.NewSectionReader(e.archive, 0, e.gzSize))
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.CopyN(ioutil.Discard, gz, e.offset); err != nil {
+			gz.Close()
+			return nil, err
+		}
+		return gz, nil
+	}, size: e.size}, nil
+}
+
+// streamReader reads a compressed entry, reopening it to seek backwards.
+// With checksum, the stream ends with the entry and an entry longer than the
+// size declared by the archive is an error.
+type streamReader struct {
+	open     func() (io.ReadCloser, error)
+	size     int64
+	checksum bool
+	offset   int64
+	pos      int64
+	rc       io.ReadCloser
+	r        io.Reader
+}
+
+func (z *streamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.offset
+	case io.SeekEnd:
+		offset += z.size
+	default:
+		return 0, errors.New("archive: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("archive: negative position")
+	}
+	z.offset = offset
+	return offset, nil
+}
+
+func (z *streamReader) Read(p []byte) (n int, err error) {
+	if z.offset >= z.size {
+		if z.checksum && z.rc != nil && z.pos == z.size {
+			// read the end of the stream, which checks the checksum
+			var b [1]byte
+			n, err := z.r.Read(b[:])
+			z.Close()
+			if n > 0 {
+				return 0, zip.ErrFormat
+			}
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+	if z.rc == nil || z.offset < z.pos {
+		z.Close()
+		if z.rc, err = z.open(); err != nil {
+			return 0, err
+		}
+		limit := z.size
+		if z.checksum {
+			limit++
+		}
+		z.r, z.pos = io.LimitReader(z.rc, limit), 0
+	}
+	if z.offset > z.pos {
+		skipped, err := io.CopyN(ioutil.Discard, z.r, z.offset-z.pos)
+		z.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = z.r.Read(p)
+	z.pos += int64(n)
+	if err == io.EOF && z.pos < z.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if z.pos > z.size {
+		return 0, zip.ErrFormat
+	}
+	z.offset = z.pos
+	return n, err
+}
+
+func (z *streamReader) Close() error {
+	if z.rc == nil {
+		return nil
+	}
+	err := z.rc.Close()
+	z.rc, z.r = nil, nil
+	return err
+}
+
+// Storage read only storage of the entries of a zip or tar archive. The
+// entries are read from the archive on demand. Tar has no index, so the
+// archive is read once to find them. The entries of tar.gz archives are
+// decompressed from the start of the archive each time they are opened,
+// which is slow for large archives but needs no disk.
+type Storage struct {
+	Config   Config
+	Endpoint oss.Endpoint
+
+	closers []io.Closer
+	assets  oss.AssetExport
+	entries map[string]*entry
+	dirs    map[string]bool
+	names   []string
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// Open opens the archive at Config.Path.
+func Open(cfg *Config) (*Storage, error) {
+	f, err := os.Open(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := openFile(f, cfg)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return storage, nil
+}
+
+// OpenStorage opens the archive pth of storage. The file downloaded by a
+// remote storage is removed by Close.
+func OpenStorage(storage oss.StorageInterface, pth string, cfg *Config) (*Storage, error) {
+	f, err := storage.Get(pth)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openFile(f, cfg)
+	if err != nil {
+		f.Close()
+		oss.RemoveSpool(f)
+		return nil, err
+	}
+	s.closers = append(s.closers, closerFunc(func() error {
+		return oss.RemoveSpool(f)
+	}))
+	return s, nil
+}
+
+func openFile(f *os.File, cfg *Config) (*Storage, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(f, info.Size(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.closers = append(s.closers, f)
+	return s, nil
+}
+
+// New reads the archive of r. It must stay readable while zip archives are
+// in use.
+func New(r io.ReaderAt, size int64, cfg *Config) (*Storage, error) {
+	if cfg.Endpoint == nil {
+		cfg.Endpoint = &oss.Endpoint{Path: "!"}
+	}
+	s := &Storage{Config: *cfg, Endpoint: *cfg.Endpoint, entries: map[string]*entry{}, dirs: map[string]bool{"": true}}
+
+	var err error
+	if s.Config.Format == "" {
+		if s.Config.Format, err = detectFormat(r); err != nil {
+			return nil, err
+		}
+	}
+
+	switch s.Config.Format {
+	case FormatZip:
+		err = s.loadZip(r, size)
+	case FormatTar:
+		err = s.loadTar(r, size, false)
+	case FormatTarGz, "tgz":
+		err = s.loadTar(r, size, true)
+	default:
+		err = fmt.Errorf("unknown format %q", s.Config.Format)
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("archive: %v", err)
+	}
+
+	for name := range s.entries {
+		s.names = append(s.names, name)
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			s.dirs[dir] = true
+		}
+	}
+	sort.Strings(s.names)
+	return s, nil
+}
+
+func detectFormat(r io.ReaderAt) (string, error) {
+	magic := make([]byte, 262)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case len(magic) >= 262 && bytes.HasPrefix(magic[257:], []byte("ustar")):
+		return FormatTar, nil
+	}
+	return "", fmt.Errorf("archive: unknown archive format")
+}
+
+func key(pth string) string {
+	return strings.Trim(path.Clean("/"+pth), "/")
+}
+
+func (this *Storage) loadZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			this.dirs[key(f.Name)] = true
+			continue
+		}
+		this.entries[key(f.Name)] = &entry{name: key(f.Name), size: int64(f.UncompressedSize64), modTime: f.Modified, zip: f, archive: r}
+	}
+	return nil
+}
+
+// countReader counts the position of the tar reader, which is the offset
+// of the content of the entry after Next.
+type countReader struct {
+	r   io.Reader
+	pos int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+// offsetReader is a countReader which lets the tar reader skip the content
+// of the entries.
+type offsetReader struct {
+	countReader
+	s io.Seeker
+}
+
+func (o *offsetReader) Seek(offset int64, whence int) (pos int64, err error) {
+	pos, err = o.s.Seek(offset, whence)
+	o.pos = pos
+	return
+}
+
+func (this *Storage) loadTar(r io.ReaderAt, size int64, compressed bool) error {
+	var (
+		cr     *countReader
+		tr     *tar.Reader
+		gzSize int64
+	)
+	if compressed {
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return err
+		}
+		cr, gzSize = &countReader{r: gz}, size
+		tr = tar.NewReader(cr)
+	} else {
+		sr := io.NewSectionReader(r, 0, size)
+		or := &offsetReader{countReader{r: sr}, sr}
+		cr = &or.countReader
+		tr = tar.NewReader(or)
+	}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeDir {
+			this.dirs[key(h.Name)] = true
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA || isSparse(h) {
+			continue
+		}
+		this.entries[key(h.Name)] = &entry{name: key(h.Name), size: h.Size, modTime: h.ModTime, archive: r, offset: cr.pos, gzSize: gzSize}
+	}
+}
+
+// isSparse reports whether h is a PAX sparse file, its content isn't stored
+// in place.
+func isSparse(h *tar.Header) bool {
+	for k := range h.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes the archive file opened by Open or OpenStorage.
+func (this *Storage) Close() error {
+	err := this.assets.Close()
+	for _, closer := range this.closers {
+		if e := closer.Close(); err == nil {
+			err = e
+		}
+	}
+	this.closers = nil
+	return err
+}
+
+func (this *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e := this.entries[key(r.URL.Path)]
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+	content, err := e.open()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if contentType := mime.TypeByExtension(path.Ext(e.name)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if c, ok := content.(io.Closer); ok {
+		defer c.Close()
+	}
+	http.ServeContent(w, r, path.Base(e.name), e.modTime, content)
+}
+
+// Stat receive file stat by path. Directories of the entries are reported
+// too.
+func (this *Storage) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	k := key(pth)
+	if e := this.entries[k]; e != nil {
+		return &fileInfo{e}, false, nil
+	}
+	if this.dirs[k] {
+		return &fileInfo{&entry{name: k, dir: true}}, false, nil
+	}
+	return nil, true, nil
+}
+
+// Get receive file with given path. Get returns an *os.File and the entry
+// isn't a file of its own, so it's streamed into an unlinked temporary file,
+// the only copy of an entry made on disk besides AssetFS.
+func (this *Storage) Get(pth string) (file *os.File, err error) {
+	e := this.entries[key(pth)]
+	if e == nil {
+		return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	}
+	content, err := e.open()
+	if err != nil {
+		return nil, err
+	}
+
+	if file, err = ioutil.TempFile("", "oss-archive"); err != nil {
+		return
+	}
+	os.Remove(file.Name())
+
+	if c, ok := content.(io.Closer); ok {
+		defer c.Close()
+	}
+	if _, err = io.Copy(file, content); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return
+}
+
+// Put return oss.ErrReadOnly.
+func (this *Storage) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return nil, &os.PathError{Op: "put", Path: pth, Err: oss.ErrReadOnly}
+}
+
+// Delete return oss.ErrReadOnly.
+func (this *Storage) Delete(pth string) error {
+	return &os.PathError{Op: "remove", Path: pth, Err: oss.ErrReadOnly}
+}
+
+// List list all objects under current path
+func (this *Storage) List(pth string) (objects []*oss.Object, err error) {
+	prefix := key(pth)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	i := sort.SearchStrings(this.names, prefix)
+	for _, name := range this.names[i:] {
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+		e := this.entries[name]
+		modTime := e.modTime
+		objects = append(objects, &oss.Object{
+			Path:             "/" + name,
+			Name:             path.Base(name),
+			LastModified:     &modTime,
+			StorageInterface: this,
+		})
+	}
+	return
+}
+
+// GetEndpoint get endpoint
+func (this *Storage) GetEndpoint() *oss.Endpoint {
+	return &this.Endpoint
+}
+
+func (this *Storage) GetURL(p ...string) (url string) {
+	url = this.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (this *Storage) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = this.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+// AssetFS exports the entries under "assets", the asset file system only
+// mounts directories. See oss.AssetExport.
+func (this *Storage) AssetFS() (assetfs.Interface, error) {
+	return this.assets.AssetFS(this)
+}
+
+type fileInfo struct {
+	e *entry
+}
+
+func (fi *fileInfo) Name() string       { return path.Base("/" + fi.e.name) }
+func (fi *fileInfo) Size() int64        { return fi.e.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.e.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.e.dir }
+func (*fileInfo) Sys() interface{}      { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.IsDir() {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
@@ -0,0 +1,268 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/memory"
+	"github.com/ecletus/oss/tests"
+)
+
+var files = map[string]string{
+	"theme/index.html":     "<html></html>",
+	"theme/css/style.css":  "body{color:red}",
+	"assets/js/app.js":     "console.log(1)",
+	"templates/a/b/c.tmpl": "{{.}}",
+}
+
+func zipArchive(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	method := zip.Store
+	for name, content := range files {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+		// mix stored and deflated entries
+		method = zip.Deflate
+	}
+	w.Create("empty/")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T, compress bool) []byte {
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	tw.WriteHeader(&tar.Header{Name: "theme/", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content)), Mode: 0644, ModTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
+
+func testStorage(t *testing.T, storage *Storage) {
+	for name, content := range files {
+		file, err := storage.Get("/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(file)
+		file.Close()
+		if string(data) != content {
+			t.Errorf("%v: bad content %q", name, data)
+		}
+
+		info, notFound, err := storage.Stat(name)
+		if err != nil || notFound || info.Size() != int64(len(content)) || info.IsDir() {
+			t.Errorf("%v: bad stat %v %v", name, notFound, err)
+		}
+	}
+
+	if info, notFound, _ := storage.Stat("templates/a"); notFound || !info.IsDir() {
+		t.Errorf("expected directory")
+	}
+	if info, notFound, _ := storage.Stat("theme"); notFound || !info.IsDir() {
+		t.Errorf("expected directory")
+	}
+	if _, notFound, _ := storage.Stat("missing"); !notFound {
+		t.Errorf("expected not found")
+	}
+
+	objects, err := storage.List("theme")
+	if err != nil || len(objects) != 2 || objects[0].Path != "/theme/css/style.css" || objects[1].Path != "/theme/index.html" {
+		t.Errorf("bad list %v %v", objects, err)
+	}
+	if objects, _ = storage.List("/"); len(objects) != len(files) {
+		t.Errorf("bad list %v", objects)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/theme/css/style.css", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	storage.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "body" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("bad response %v %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	if _, err = storage.Put("a", strings.NewReader("a")); !oss.IsErrReadOnly(err) {
+		t.Errorf("expected read only, got %v", err)
+	}
+	if err = storage.Delete("theme/index.html"); !oss.IsErrReadOnly(err) {
+		t.Errorf("expected read only, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for format, data := range map[string][]byte{
+		FormatZip:   zipArchive(t),
+		FormatTar:   tarArchive(t, false),
+		FormatTarGz: tarArchive(t, true),
+	} {
+		t.Run(format, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "bundle")
+			if err := ioutil.WriteFile(pth, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			storage, err := Open(&Config{Path: pth})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer storage.Close()
+			if storage.Config.Format != format {
+				t.Errorf("detected %v", storage.Config.Format)
+			}
+			testStorage(t, storage)
+		})
+	}
+}
+
+func TestOpenStorage(t *testing.T) {
+	source := memory.New(&memory.Config{})
+	if _, err := source.Put("bundles/theme.zip", bytes.NewReader(zipArchive(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := OpenStorage(source, "bundles/theme.zip", &Config{Format: FormatZip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	testStorage(t, storage)
+
+	var _ oss.StorageInterface = storage
+}
+
+func TestOpenStorageRemovesSpool(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	source := tests.SpoolStorage{StorageInterface: memory.New(&memory.Config{})}
+	if _, err := source.Put("theme.tgz", bytes.NewReader(tarArchive(t, true))); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := OpenStorage(source, "theme.tgz", &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, storage)
+	if err = storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(tmp, "*")); len(files) != 0 {
+		t.Errorf("temporary files not removed: %v", files)
+	}
+}
+
+func TestZipEntries(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, _ := w.Create("a.txt")
+	f.Write([]byte(content))
+
+	// an entry declaring less than its content
+	var deflated bytes.Buffer
+	fw, _ := flate.NewWriter(&deflated, flate.BestCompression)
+	fw.Write([]byte(content))
+	fw.Close()
+	raw, _ := w.CreateRaw(&zip.FileHeader{Name: "bomb.txt", Method: zip.Deflate, CRC32: crc32.ChecksumIEEE([]byte(content)),
+		CompressedSize64: uint64(deflated.Len()), UncompressedSize64: 10})
+	raw.Write(deflated.Bytes())
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()), &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ranges of a compressed entry, forwards and backwards
+	for r, body := range map[string]string{"bytes=9990-9999": "0123456789", "bytes=5-7": "567", "bytes=-3": "789"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/a.txt", nil)
+		req.Header.Set("Range", r)
+		storage.ServeHTTP(w, req)
+		if w.Code != http.StatusPartialContent || w.Body.String() != body {
+			t.Errorf("%v: bad response %v %q", r, w.Code, w.Body.String())
+		}
+	}
+	r, _ := storage.entries["a.txt"].open()
+	head := make([]byte, 5)
+	io.ReadFull(r, head)
+	r.Seek(2, io.SeekStart)
+	if _, err = io.ReadFull(r, head); err != nil || string(head) != "23456" {
+		t.Errorf("bad read after seeking backwards %q %v", head, err)
+	}
+
+	if file, err := storage.Get("a.txt"); err != nil {
+		t.Error(err)
+	} else {
+		data, _ := ioutil.ReadAll(file)
+		file.Close()
+		if string(data) != content {
+			t.Errorf("bad content of %d bytes", len(data))
+		}
+	}
+
+	if _, err = storage.Get("bomb.txt"); err == nil {
+		t.Errorf("expected an error for an entry longer than declared")
+	}
+}
+
+func TestTarGzEntries(t *testing.T) {
+	data := tarArchive(t, true)
+	storage, err := New(bytes.NewReader(data), int64(len(data)), &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := files["theme/css/style.css"]
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/theme/css/style.css", nil)
+	req.Header.Set("Range", "bytes=5-9")
+	storage.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != content[5:10] {
+		t.Errorf("bad response %v %q", w.Code, w.Body.String())
+	}
+
+	r, _ := storage.entries["theme/css/style.css"].open()
+	head := make([]byte, 5)
+	io.ReadFull(r, head)
+	r.Seek(2, io.SeekStart)
+	if _, err = io.ReadFull(r, head); err != nil || string(head) != content[2:7] {
+		t.Errorf("bad read after seeking backwards %q %v", head, err)
+	}
+}
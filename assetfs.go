@@ -0,0 +1,102 @@
+package oss
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/moisespsena-go/assetfs"
+	errwrap "github.com/moisespsena-go/error-wrap"
+)
+
+// AssetExport serves the objects under the "assets" directory of a storage
+// that can't be mounted as an asset file system. They are copied into a
+// temporary directory on the first call of AssetFS and later calls return
+// the same snapshot, later writes to the storage aren't seen. Close removes
+// the directory.
+type AssetExport struct {
+	mu  sync.Mutex
+	dir string
+	fs  assetfs.Interface
+}
+
+// AssetFS returns the exported assets of storage.
+func (this *AssetExport) AssetFS(storage StorageInterface) (assetfs.Interface, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.fs != nil {
+		return this.fs, nil
+	}
+
+	dir, fs, err := exportAssets(storage)
+	if err != nil {
+		return nil, err
+	}
+	this.dir, this.fs = dir, fs
+	return fs, nil
+}
+
+// Close removes the exported assets. The next AssetFS call exports them again.
+func (this *AssetExport) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(this.dir)
+	this.dir, this.fs = "", nil
+	return err
+}
+
+func exportAssets(storage StorageInterface) (dir string, _ assetfs.Interface, err error) {
+	objects, err := storage.List("assets")
+	if err != nil {
+		return "", nil, errwrap.Wrap(err, "List assets")
+	}
+
+	if dir, err = ioutil.TempDir("", "oss-assets"); err != nil {
+		return "", nil, errwrap.Wrap(err, "Create assets directory")
+	}
+
+	for _, object := range objects {
+		rel := strings.TrimPrefix(strings.TrimPrefix(object.Path, "/"), "assets/")
+		if err = exportObject(storage, object.Path, filepath.Join(dir, filepath.FromSlash(rel))); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, errwrap.Wrap(err, "Export asset %q", object.Path)
+		}
+	}
+
+	fs := assetfs.NewAssetFileSystem()
+	if err = fs.RegisterPath(dir); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, errwrap.Wrap(err, "Register path %q", dir)
+	}
+	return dir, fs, nil
+}
+
+func exportObject(storage StorageInterface, pth, dst string) error {
+	src, err := storage.Get(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		src.Close()
+		RemoveSpool(src)
+	}()
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
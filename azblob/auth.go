@@ -0,0 +1,129 @@
+package azblob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sign signs req with the shared key of the account.
+func (client *Client) sign(req *http.Request) {
+	req.Header.Set("Authorization", "SharedKey "+client.Config.AccountName+":"+client.hmac(sharedKeyStringToSign(client.Config.AccountName, req)))
+}
+
+func (client *Client) hmac(s string) string {
+	h := hmac.New(sha256.New, client.key)
+	h.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sharedKeyStringToSign returns the string to sign of the Shared Key
+// authorization of req.
+func sharedKeyStringToSign(account string, req *http.Request) string {
+	contentLength := req.Header.Get("Content-Length")
+	if contentLength == "" && req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if contentLength == "0" {
+		contentLength = ""
+	}
+
+	var msHeaders []string
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.Join(values, ","))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	h := req.Header
+	parts := []string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date, x-ms-date is used
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	}
+	parts = append(parts, msHeaders...)
+	parts = append(parts, resource)
+	return strings.Join(parts, "\n")
+}
+
+// sasStringToSign returns the string to sign of a blob service SAS.
+func sasStringToSign(account, container, name string, query url.Values) string {
+	return strings.Join([]string{
+		query.Get("sp"),
+		query.Get("st"),
+		query.Get("se"),
+		"/blob/" + account + "/" + container + "/" + name,
+		query.Get("si"),
+		query.Get("sip"),
+		query.Get("spr"),
+		query.Get("sv"),
+		query.Get("sr"),
+		"", // snapshot time
+		query.Get("ses"),
+		query.Get("rscc"),
+		query.Get("rscd"),
+		query.Get("rsce"),
+		query.Get("rscl"),
+		query.Get("rsct"),
+	}, "\n")
+}
+
+// SignedURL returns a read only SAS URL of the blob, valid for expires.
+// Zero expires uses Config.SASExpires. Without AccountKey the URL carries the
+// configured SASToken.
+func (client *Client) SignedURL(pth string, expires time.Duration) (string, error) {
+	return client.signedURL("r", pth, expires, time.Now())
+}
+
+func (client *Client) signedURL(permissions, pth string, expires time.Duration, now time.Time) (string, error) {
+	name := client.ToRelativePath(pth)
+	if client.key == nil {
+		if client.Config.SASToken == "" {
+			return "", ErrSigningUnavailable
+		}
+		return client.blobURL(name) + "?" + client.Config.SASToken, nil
+	}
+	if expires <= 0 {
+		expires = time.Duration(client.Config.SASExpires) * time.Second
+	}
+
+	query := url.Values{
+		"sv": {apiVersion},
+		"sr": {"b"},
+		"sp": {permissions},
+		"st": {now.UTC().Add(-5 * time.Minute).Format(sasTimeFormat)},
+		"se": {now.UTC().Add(expires).Format(sasTimeFormat)},
+	}
+	query.Set("sig", client.hmac(sasStringToSign(client.Config.AccountName, client.Config.Container, name, query)))
+	return client.blobURL(name) + "?" + query.Encode(), nil
+}
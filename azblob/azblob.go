@@ -0,0 +1,550 @@
+package azblob
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+	"github.com/ecletus/oss/internal/httpproxy"
+)
+
+const (
+	apiVersion       = "2020-12-06"
+	sasTimeFormat    = "2006-01-02T15:04:05Z"
+	defaultBlockSize = 4 << 20
+)
+
+// ErrSigningUnavailable is returned by SignedURL when the client has neither
+// an account key nor a SAS token.
+var ErrSigningUnavailable = errors.New("azblob: SAS URLs require an account key or a SAS token")
+
+func init() {
+	factories.Registry("azblob", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil {
+			ctx.Var.FormatPtr(&cfg.AccountName, &cfg.Container, &cfg.ServiceURL, &cfg.Endpoint.Path, &cfg.Endpoint.Host)
+		}
+		return New(&cfg)
+	}))
+}
+
+// Config Azure Blob Storage client config
+type Config struct {
+	AccountName string
+	// Base64 account key, for Shared Key authorization.
+	AccountKey string
+	// SAS token, with or without leading "?", used when AccountKey is empty.
+	SASToken  string
+	Container string
+	// Blob service URL. Default is https://<AccountName>.blob.core.windows.net.
+	// Emulators use path style URLs, e.g. http://127.0.0.1:10000/devstoreaccount1.
+	ServiceURL string
+	// Public URL of the blobs. Default is <ServiceURL>/<Container>.
+	Endpoint oss.Endpoint
+	// Access tier of the uploaded blobs: Hot, Cool or Archive. Empty uses the
+	// account default.
+	AccessTier string
+	// value in bytes. Size of the uploaded blocks. Default is 4MiB.
+	BlockSize int
+	// value in seconds. Expiration of the URLs of SignedURL. Default is one hour.
+	SASExpires int64
+}
+
+// Client Azure Blob Storage
+type Client struct {
+	Config     *Config
+	HTTPClient *http.Client
+	key        []byte
+}
+
+var _ oss.MetadataStorageInterface = (*Client)(nil)
+
+// New initialize Azure Blob storage
+func New(config *Config) (client *Client, err error) {
+	if config.AccountName == "" || config.Container == "" {
+		return nil, fmt.Errorf("azblob: account name and container are required")
+	}
+	client = &Client{Config: config, HTTPClient: http.DefaultClient}
+
+	if config.AccountKey != "" {
+		if client.key, err = base64.StdEncoding.DecodeString(config.AccountKey); err != nil {
+			return nil, fmt.Errorf("azblob: invalid account key: %v", err)
+		}
+	}
+	config.SASToken = strings.TrimPrefix(config.SASToken, "?")
+
+	if config.ServiceURL == "" {
+		config.ServiceURL = "https://" + config.AccountName + ".blob.core.windows.net"
+	}
+	config.ServiceURL = strings.TrimSuffix(config.ServiceURL, "/")
+
+	if config.Endpoint.Host == "" && config.Endpoint.Path == "" {
+		u, err := url.Parse(config.ServiceURL)
+		if err != nil {
+			return nil, fmt.Errorf("azblob: invalid service URL: %v", err)
+		}
+		config.Endpoint = oss.Endpoint{Scheme: u.Scheme, Host: u.Host, Path: u.Path + "/" + config.Container}
+	}
+	if config.BlockSize <= 0 {
+		config.BlockSize = defaultBlockSize
+	}
+	if config.SASExpires <= 0 {
+		config.SASExpires = 3600
+	}
+	return client, nil
+}
+
+// Error Blob service error
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("azblob: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// ToRelativePath returns the blob name of path
+func (client *Client) ToRelativePath(pth string) string {
+	return strings.TrimPrefix(path.Clean("/"+pth), "/")
+}
+
+func (client *Client) containerURL() string {
+	return client.Config.ServiceURL + "/" + url.PathEscape(client.Config.Container)
+}
+
+func (client *Client) blobURL(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return client.containerURL() + "/" + strings.Join(segments, "/")
+}
+
+func (client *Client) newRequest(method, u string, query url.Values, body io.Reader) (*http.Request, error) {
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	if client.key == nil && client.Config.SASToken != "" {
+		if len(query) > 0 {
+			u += "&" + client.Config.SASToken
+		} else {
+			u += "?" + client.Config.SASToken
+		}
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	return req, nil
+}
+
+func (client *Client) do(req *http.Request) (*http.Response, error) {
+	if client.key != nil {
+		client.sign(req)
+	}
+	res, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotModified {
+		defer res.Body.Close()
+		e := &Error{StatusCode: res.StatusCode, Code: res.Header.Get("x-ms-error-code")}
+		var body struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if data, _ := ioutil.ReadAll(res.Body); xml.Unmarshal(data, &body) == nil {
+			if body.Code != "" {
+				e.Code = body.Code
+			}
+			e.Message = body.Message
+		}
+		if e.Message == "" {
+			e.Message = http.StatusText(res.StatusCode)
+		}
+		return nil, e
+	}
+	return res, nil
+}
+
+func (client *Client) call(method, u string, query url.Values, body io.Reader, header http.Header) error {
+	req, err := client.newRequest(method, u, query, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := client.do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return res.Body.Close()
+}
+
+// ServeHTTP streams the blob, signing the request with the account
+// credentials.
+func (client *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	httpproxy.Serve(w, r, func(method string) (*http.Request, error) {
+		return client.newRequest(method, client.blobURL(client.ToRelativePath(r.URL.Path)), nil, nil)
+	}, client.do, IsNotFound)
+}
+
+// Stat receive file stat by path
+func (client *Client) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	name := client.ToRelativePath(pth)
+	req, err := client.newRequest(http.MethodHead, client.blobURL(name), nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := client.do(req)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	res.Body.Close()
+
+	b := &blob{Name: name}
+	b.Properties.ContentLength = res.ContentLength
+	b.Properties.ContentType = res.Header.Get("Content-Type")
+	b.Properties.ContentDisposition = res.Header.Get("Content-Disposition")
+	b.Properties.AccessTier = res.Header.Get("x-ms-access-tier")
+	b.Properties.LastModified = res.Header.Get("Last-Modified")
+	for k, v := range res.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-meta-") {
+			if b.Metadata.Values == nil {
+				b.Metadata.Values = map[string]string{}
+			}
+			b.Metadata.Values[strings.TrimPrefix(k, "x-ms-meta-")] = v[0]
+		}
+	}
+	return &fileInfo{b}, false, nil
+}
+
+// Get receive file with given path
+func (client *Client) Get(pth string) (file *os.File, err error) {
+	req, err := client.newRequest(http.MethodGet, client.blobURL(client.ToRelativePath(pth)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.do(req)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if file, err = oss.NewSpool(); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(file, res.Body); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// Put store a reader into given path
+func (client *Client) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return client.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader into given path with metadata. Readers up
+// to BlockSize are uploaded in one request, larger ones are streamed in
+// blocks of BlockSize and committed with a block list.
+func (client *Client) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	var (
+		name   = client.ToRelativePath(pth)
+		u      = client.blobURL(name)
+		buf    = make([]byte, client.Config.BlockSize)
+		blocks []string
+	)
+
+	header := http.Header{}
+	if metadata == nil {
+		metadata = &oss.Metadata{}
+	}
+	contentType := metadata.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if metadata.ContentDisposition != "" {
+		header.Set("x-ms-blob-content-disposition", metadata.ContentDisposition)
+	}
+	for k, v := range metadata.Custom {
+		header.Set("x-ms-meta-"+k, v)
+	}
+	if client.Config.AccessTier != "" {
+		header.Set("x-ms-access-tier", client.Config.AccessTier)
+	}
+
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(blocks) == 0 && n < len(buf) {
+			// single request upload
+			if contentType == "" {
+				contentType = http.DetectContentType(buf[:n])
+			}
+			header.Set("x-ms-blob-content-type", contentType)
+			header.Set("x-ms-blob-type", "BlockBlob")
+			if err = client.call(http.MethodPut, u, nil, bytes.NewReader(buf[:n]), header); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		if n > 0 {
+			if contentType == "" {
+				contentType = http.DetectContentType(buf[:n])
+			}
+			id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%010d", len(blocks))))
+			query := url.Values{"comp": {"block"}, "blockid": {id}}
+			if err = client.call(http.MethodPut, u, query, bytes.NewReader(buf[:n]), nil); err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, id)
+		}
+
+		if n < len(buf) {
+			var list bytes.Buffer
+			list.WriteString(xml.Header + "<BlockList>")
+			for _, id := range blocks {
+				list.WriteString("<Latest>" + id + "</Latest>")
+			}
+			list.WriteString("</BlockList>")
+
+			header.Set("x-ms-blob-content-type", contentType)
+			if err = client.call(http.MethodPut, u, url.Values{"comp": {"blocklist"}}, &list, header); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	now := time.Now()
+	m := &oss.Metadata{ContentType: contentType, ContentDisposition: metadata.ContentDisposition, Custom: metadata.Custom}
+	return &oss.Object{
+		Path:             pth,
+		Name:             path.Base(name),
+		LastModified:     &now,
+		Metadata:         m,
+		StorageInterface: client,
+	}, nil
+}
+
+// SetAccessTier sets the access tier of the blob: Hot, Cool or Archive.
+func (client *Client) SetAccessTier(pth, tier string) error {
+	return client.call(http.MethodPut, client.blobURL(client.ToRelativePath(pth)), url.Values{"comp": {"tier"}},
+		nil, http.Header{"X-Ms-Access-Tier": {tier}})
+}
+
+// Delete delete file
+func (client *Client) Delete(pth string) error {
+	err := client.call(http.MethodDelete, client.blobURL(client.ToRelativePath(pth)), nil, nil, nil)
+	if IsNotFound(err) {
+		return &os.PathError{Op: "remove", Path: pth, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+type blob struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		LastModified       string `xml:"Last-Modified"`
+		ContentLength      int64  `xml:"Content-Length"`
+		ContentType        string `xml:"Content-Type"`
+		ContentDisposition string `xml:"Content-Disposition"`
+		AccessTier         string `xml:"AccessTier"`
+	} `xml:"Properties"`
+	Metadata blobMetadata `xml:"Metadata"`
+}
+
+func (b *blob) modTime() time.Time {
+	t, _ := http.ParseTime(b.Properties.LastModified)
+	return t
+}
+
+func (b *blob) metadata() *oss.Metadata {
+	m := &oss.Metadata{ContentType: b.Properties.ContentType, ContentDisposition: b.Properties.ContentDisposition, Custom: b.Metadata.Values}
+	if m.IsZero() {
+		return nil
+	}
+	return m
+}
+
+// blobMetadata decodes the free form elements of <Metadata>.
+type blobMetadata struct {
+	Values map[string]string
+}
+
+func (m *blobMetadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var v string
+			if err = d.DecodeElement(&v, &t); err != nil {
+				return err
+			}
+			if m.Values == nil {
+				m.Values = map[string]string{}
+			}
+			m.Values[strings.ToLower(t.Name.Local)] = v
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// List list all objects under current path
+func (client *Client) List(pth string) (objects []*oss.Object, err error) {
+	var (
+		page   []*oss.Object
+		marker string
+	)
+	for {
+		if page, marker, err = client.ListPage(pth, marker, 0); err != nil {
+			return nil, err
+		}
+		objects = append(objects, page...)
+		if marker == "" {
+			return
+		}
+	}
+}
+
+// ListPage list a page of maxResults blobs under current path, zero uses the
+// service default. next is the marker of the next page, or empty.
+func (client *Client) ListPage(pth, marker string, maxResults int) (objects []*oss.Object, next string, err error) {
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "include": {"metadata"}}
+	if prefix := client.ToRelativePath(pth); prefix != "" {
+		query.Set("prefix", prefix+"/")
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	if maxResults > 0 {
+		query.Set("maxresults", strconv.Itoa(maxResults))
+	}
+
+	req, err := client.newRequest(http.MethodGet, client.containerURL(), query, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := client.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Blobs      []*blob `xml:"Blobs>Blob"`
+		NextMarker string  `xml:"NextMarker"`
+	}
+	if err = xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	for _, b := range result.Blobs {
+		modTime := b.modTime()
+		objects = append(objects, &oss.Object{
+			Path:             "/" + b.Name,
+			Name:             path.Base(b.Name),
+			LastModified:     &modTime,
+			Metadata:         b.metadata(),
+			StorageInterface: client,
+		})
+	}
+	return objects, result.NextMarker, nil
+}
+
+// GetEndpoint get endpoint
+func (client *Client) GetEndpoint() *oss.Endpoint {
+	return &client.Config.Endpoint
+}
+
+func (client *Client) GetURL(p ...string) (url string) {
+	url = client.Config.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = client.Config.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) AssetFS() (assetfs.Interface, error) {
+	return nil, oss.ErrAssetFsUnavailable
+}
+
+type fileInfo struct {
+	blob *blob
+}
+
+func (fi *fileInfo) Name() string            { return path.Base(fi.blob.Name) }
+func (fi *fileInfo) Size() int64             { return fi.blob.Properties.ContentLength }
+func (*fileInfo) Mode() os.FileMode          { return 0400 }
+func (fi *fileInfo) ModTime() time.Time      { return fi.blob.modTime() }
+func (*fileInfo) IsDir() bool                { return false }
+func (*fileInfo) Sys() interface{}           { return nil }
+func (fi *fileInfo) Metadata() *oss.Metadata { return fi.blob.metadata() }
+
+// AccessTier returns the access tier of the blob.
+func (fi *fileInfo) AccessTier() string { return fi.blob.Properties.AccessTier }
@@ -0,0 +1,339 @@
+package azblob
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/tests"
+)
+
+const (
+	account    = "devstoreaccount1"
+	accountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	container  = "container"
+)
+
+// fakeServer implements the subset of the Azurite blob service used by
+// Client. It only checks the shape of the credentials, the signatures are
+// checked against known vectors by TestSignatures.
+type fakeServer struct {
+	mu          sync.Mutex
+	blobs       map[string]*fakeBlob
+	uncommitted map[string]map[string][]byte
+	requests    []string
+}
+
+type fakeBlob struct {
+	data    []byte
+	header  http.Header
+	modTime time.Time
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *httptest.Server) {
+	fake := &fakeServer{blobs: map[string]*fakeBlob{}, uncommitted: map[string]map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (s *fakeServer) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (s *fakeServer) authorized(r *http.Request) bool {
+	q := r.URL.Query()
+	switch {
+	case r.Header.Get("Authorization") != "":
+		sig := strings.TrimPrefix(r.Header.Get("Authorization"), "SharedKey "+account+":")
+		_, err := base64.StdEncoding.DecodeString(sig)
+		return err == nil && len(sig) == 44 && r.Header.Get("x-ms-date") != "" && r.Header.Get("x-ms-version") == apiVersion
+	case q.Get("sig") == "token":
+		return true
+	case q.Get("sig") != "" && r.Method == http.MethodGet:
+		se, err := time.Parse(sasTimeFormat, q.Get("se"))
+		return err == nil && time.Now().Before(se) && q.Get("sp") == "r" && q.Get("sr") == "b"
+	}
+	return false
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := r.URL.Query()
+	s.requests = append(s.requests, r.Method+" "+q.Get("comp"))
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != account || parts[1] != container {
+		s.error(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	var name string
+	if len(parts) == 3 {
+		name = parts[2]
+	}
+	if !s.authorized(r) {
+		s.error(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+
+	if name == "" {
+		if q.Get("restype") == "container" && q.Get("comp") == "list" {
+			s.list(w, r)
+		} else {
+			s.error(w, http.StatusBadRequest, "InvalidQueryParameterValue")
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		switch q.Get("comp") {
+		case "":
+			if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+				s.error(w, http.StatusBadRequest, "MissingRequiredHeader")
+				return
+			}
+			s.blobs[name] = &fakeBlob{data, r.Header, time.Now()}
+		case "block":
+			if s.uncommitted[name] == nil {
+				s.uncommitted[name] = map[string][]byte{}
+			}
+			s.uncommitted[name][q.Get("blockid")] = data
+		case "blocklist":
+			var list struct {
+				Latest []string `xml:"Latest"`
+			}
+			if xml.Unmarshal(data, &list) != nil {
+				s.error(w, http.StatusBadRequest, "InvalidXmlDocument")
+				return
+			}
+			var content []byte
+			for _, id := range list.Latest {
+				block, ok := s.uncommitted[name][id]
+				if !ok {
+					s.error(w, http.StatusBadRequest, "InvalidBlockList")
+					return
+				}
+				content = append(content, block...)
+			}
+			delete(s.uncommitted, name)
+			s.blobs[name] = &fakeBlob{content, r.Header, time.Now()}
+		case "tier":
+			b := s.blobs[name]
+			if b == nil {
+				s.error(w, http.StatusNotFound, "BlobNotFound")
+				return
+			}
+			b.header.Set("x-ms-access-tier", r.Header.Get("x-ms-access-tier"))
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		b := s.blobs[name]
+		if b == nil {
+			s.error(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		for k, v := range b.header {
+			if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-meta-") || k == "x-ms-access-tier" {
+				w.Header()[k] = v
+			}
+		}
+		w.Header().Set("Content-Type", b.header.Get("x-ms-blob-content-type"))
+		if cd := b.header.Get("x-ms-blob-content-disposition"); cd != "" {
+			w.Header().Set("Content-Disposition", cd)
+		}
+		http.ServeContent(w, r, name, b.modTime, bytes.NewReader(b.data))
+	case http.MethodDelete:
+		if s.blobs[name] == nil {
+			s.error(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *fakeServer) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var names []string
+	for name := range s.blobs {
+		if strings.HasPrefix(name, q.Get("prefix")) && name > q.Get("marker") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	max, _ := strconv.Atoi(q.Get("maxresults"))
+	if max == 0 {
+		max = 2
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+	for i, name := range names {
+		if i == max {
+			break
+		}
+		b := s.blobs[name]
+		fmt.Fprintf(&buf, "<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified><Content-Length>%d</Content-Length><Content-Type>%s</Content-Type></Properties><Metadata>",
+			name, b.modTime.UTC().Format(http.TimeFormat), len(b.data), b.header.Get("x-ms-blob-content-type"))
+		for k, v := range b.header {
+			if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-meta-") {
+				fmt.Fprintf(&buf, "<%s>%s</%s>", k[10:], v[0], k[10:])
+			}
+		}
+		buf.WriteString("</Metadata></Blob>")
+	}
+	buf.WriteString("</Blobs><NextMarker>")
+	if len(names) > max {
+		buf.WriteString(names[max-1])
+	}
+	buf.WriteString("</NextMarker></EnumerationResults>")
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(buf.Bytes())
+}
+
+func newTestClient(t *testing.T, config *Config) (*Client, *fakeServer) {
+	fake, server := newFakeServer(t)
+	config.AccountName, config.Container = account, container
+	config.ServiceURL = server.URL + "/" + account
+	if config.SASToken == "" {
+		config.AccountKey = accountKey
+	}
+	client, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, fake
+}
+
+func TestAll(t *testing.T) {
+	client, _ := newTestClient(t, &Config{})
+	tests.TestAll(client, t)
+}
+
+func TestSASToken(t *testing.T) {
+	client, _ := newTestClient(t, &Config{SASToken: "?sv=2020-12-06&sig=token"})
+	tests.TestAll(client, t)
+
+	client.Config.SASToken = "sig=bad"
+	if _, err := client.Put("a", strings.NewReader("a")); err == nil || !strings.Contains(err.Error(), "AuthenticationFailed") {
+		t.Errorf("expected AuthenticationFailed, got %v", err)
+	}
+}
+
+func TestBlockUpload(t *testing.T) {
+	client, fake := newTestClient(t, &Config{BlockSize: 4, AccessTier: "Cool"})
+	metadata := &oss.Metadata{ContentType: "text/x-a", ContentDisposition: "attachment", Custom: map[string]string{"k": "v"}}
+	if _, err := client.PutWithMetadata("/dir/a b.txt", strings.NewReader("0123456789"), metadata); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(fake.requests, ","); got != "PUT block,PUT block,PUT block,PUT blocklist" {
+		t.Errorf("bad requests %v", got)
+	}
+
+	info, notFound, err := client.Stat("dir/a b.txt")
+	if err != nil || notFound {
+		t.Fatalf("bad stat %v %v", notFound, err)
+	}
+	m := oss.GetMetadata(info)
+	if info.Size() != 10 || m.ContentType != "text/x-a" || m.ContentDisposition != "attachment" || m.Custom["k"] != "v" ||
+		info.(*fileInfo).AccessTier() != "Cool" {
+		t.Errorf("bad stat %v %+v", info.Size(), m)
+	}
+
+	if err = client.SetAccessTier("dir/a b.txt", "Hot"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _, _ = client.Stat("dir/a b.txt"); info.(*fileInfo).AccessTier() != "Hot" {
+		t.Errorf("access tier not changed")
+	}
+
+	tests.TestServeHTTP(client, "/dir/a%20b.txt", "0123456789", t)
+
+	objects, next, err := client.ListPage("dir", "", 1)
+	if err != nil || len(objects) != 1 || next != "" || objects[0].Metadata.Custom["k"] != "v" {
+		t.Errorf("bad list %v %q %v", objects, next, err)
+	}
+
+	if err = client.Delete("missing"); !os.IsNotExist(err) {
+		t.Errorf("Delete: expected not exist, got %v", err)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	client, _ := newTestClient(t, &Config{})
+	if _, err := client.Put("dir/a b.txt", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := client.SignedURL("/dir/a b.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(data) != "data" {
+		t.Errorf("bad signed response %v %q", res.StatusCode, data)
+	}
+}
+
+// TestSignatures checks the strings to sign, written by hand from the
+// service documentation, and their signatures, computed with
+// openssl dgst -sha256 -mac HMAC.
+func TestSignatures(t *testing.T) {
+	client, err := New(&Config{AccountName: account, AccountKey: accountKey, Container: container})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("PUT", "https://"+account+".blob.core.windows.net/container/dir/a%20b.txt?comp=block&blockid=AAAA", strings.NewReader("data"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("x-ms-date", "Thu, 02 Jan 2020 03:04:05 GMT")
+	req.Header.Set("x-ms-version", "2020-12-06")
+	if s := sharedKeyStringToSign(account, req); s != "PUT\n\n\n4\n\ntext/plain\n\n\n\n\n\n\n"+
+		"x-ms-date:Thu, 02 Jan 2020 03:04:05 GMT\nx-ms-version:2020-12-06\n"+
+		"/devstoreaccount1/container/dir/a%20b.txt\nblockid:AAAA\ncomp:block" {
+		t.Errorf("bad Shared Key string to sign %q", s)
+	}
+	client.sign(req)
+	if auth := req.Header.Get("Authorization"); auth != "SharedKey devstoreaccount1:k2BDalHQov9fhgWC5jKILz/dPYmhxLg8tz27+bm3+rM=" {
+		t.Errorf("bad Shared Key signature %q", auth)
+	}
+
+	signed, err := client.signedURL("r", "/dir/a b.txt", time.Hour, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signed)
+	q := u.Query()
+	if s := sasStringToSign(account, container, "dir/a b.txt", q); s != "r\n2020-01-02T02:59:05Z\n2020-01-02T04:04:05Z\n"+
+		"/blob/devstoreaccount1/container/dir/a b.txt\n\n\n\n2020-12-06\nb\n\n\n\n\n\n\n" {
+		t.Errorf("bad SAS string to sign %q", s)
+	}
+	if sig := q.Get("sig"); sig != "dcyKzsL7PsYrCy9EZo761Gk4U6N1OdDTiWG++NHv/+s=" {
+		t.Errorf("bad SAS signature %q", sig)
+	}
+}
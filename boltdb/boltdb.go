@@ -0,0 +1,455 @@
+package boltdb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+	"github.com/ecletus/oss/internal/chunks"
+)
+
+func init() {
+	factories.Registry("bolt", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil {
+			ctx.Var.FormatPathPtr(&cfg.Path)
+			if cfg.Endpoint != nil {
+				ctx.Var.FormatPtr(&cfg.Endpoint.Path, &cfg.Endpoint.Host)
+			}
+		}
+		return Open(&cfg)
+	}))
+}
+
+const (
+	defaultChunkSize = 256 << 10
+	// bytes of chunks written by each transaction of Put
+	txBytes = 4 << 20
+)
+
+var (
+	bucketObjects = []byte("objects")
+	bucketChunks  = []byte("chunks")
+	bucketPending = []byte("pending")
+)
+
+// ErrObjectChanged is returned when the record being read is replaced.
+var ErrObjectChanged = chunks.ErrObjectChanged
+
+type Config struct {
+	// Path of the database file.
+	Path string
+	// value in bytes. Size of the stored chunks. Default is 256KiB.
+	ChunkSize int
+	// value in milliseconds. Time to wait for the file lock. Default is one
+	// second.
+	LockTimeout int64
+	Endpoint    *oss.Endpoint
+}
+
+var _ oss.MetadataStorageInterface = (*Storage)(nil)
+
+// Storage bbolt storage, for single node deployments. Objects are split in
+// chunks of ChunkSize under a random version, written in transactions of
+// few MiB. The object record is switched to the new version only when all
+// chunks are committed, so a crash leaves the previous object in place;
+// the chunks of interrupted writes are removed by Open.
+type Storage struct {
+	Config   Config
+	Endpoint oss.Endpoint
+	DB       *bolt.DB
+
+	assets oss.AssetExport
+}
+
+// Open opens or creates the database at Config.Path.
+func Open(cfg *Config) (*Storage, error) {
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 1000
+	}
+	if cfg.Endpoint == nil {
+		cfg.Endpoint = &oss.Endpoint{Path: "!"}
+	}
+
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: time.Duration(cfg.LockTimeout) * time.Millisecond})
+	if err != nil {
+		return nil, fmt.Errorf("boltdb: open %q: %v", cfg.Path, err)
+	}
+
+	this := &Storage{Config: *cfg, Endpoint: *cfg.Endpoint, DB: db}
+	if err = db.Update(this.init); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("boltdb: init %q: %v", cfg.Path, err)
+	}
+	return this, nil
+}
+
+// init creates the buckets and removes the chunks of interrupted writes.
+func (this *Storage) init(tx *bolt.Tx) error {
+	for _, name := range [][]byte{bucketObjects, bucketChunks, bucketPending} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+
+	pending := tx.Bucket(bucketPending)
+	var versions [][]byte
+	pending.ForEach(func(k, _ []byte) error {
+		versions = append(versions, append([]byte{}, k...))
+		return nil
+	})
+	for _, version := range versions {
+		if err := deleteChunks(tx, version); err != nil {
+			return err
+		}
+		if err := pending.Delete(version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (this *Storage) Close() error {
+	err := this.assets.Close()
+	if e := this.DB.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func key(pth string) string {
+	return strings.Trim(path.Clean("/"+pth), "/")
+}
+
+type record struct {
+	Version   []byte
+	Size      int64
+	ChunkSize int
+	ModTime   time.Time
+	Metadata  *oss.Metadata `json:",omitempty"`
+}
+
+func chunkKey(version []byte, seq uint32) []byte {
+	k := make([]byte, len(version)+4)
+	copy(k, version)
+	binary.BigEndian.PutUint32(k[len(version):], seq)
+	return k
+}
+
+func deleteChunks(tx *bolt.Tx, version []byte) error {
+	c := tx.Bucket(bucketChunks).Cursor()
+	for k, _ := c.Seek(version); k != nil && bytes.HasPrefix(k, version); k, _ = c.Seek(version) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Storage) get(pth string) (rec *record, err error) {
+	err = this.DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketObjects).Get([]byte(key(pth)))
+		if data == nil {
+			return nil
+		}
+		rec = &record{}
+		return json.Unmarshal(data, rec)
+	})
+	return
+}
+
+func (this *Storage) newReader(rec *record) *chunks.Reader {
+	return chunks.NewReader(rec.Size, rec.ChunkSize, func(seq int64, buf []byte) (chunk []byte, err error) {
+		err = this.DB.View(func(tx *bolt.Tx) error {
+			if data := tx.Bucket(bucketChunks).Get(chunkKey(rec.Version, uint32(seq))); data != nil {
+				chunk = append(buf, data...)
+			}
+			return nil
+		})
+		return
+	})
+}
+
+func (this *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec, err := this.get(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rec == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if rec.Metadata != nil {
+		if rec.Metadata.ContentType != "" {
+			w.Header().Set("Content-Type", rec.Metadata.ContentType)
+		}
+		if rec.Metadata.ContentDisposition != "" {
+			w.Header().Set("Content-Disposition", rec.Metadata.ContentDisposition)
+		}
+	}
+	http.ServeContent(w, r, path.Base(key(r.URL.Path)), rec.ModTime, this.newReader(rec))
+}
+
+func (this *Storage) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	rec, err := this.get(pth)
+	if err != nil {
+		return nil, false, err
+	}
+	if rec == nil {
+		return nil, true, nil
+	}
+	return chunks.NewFileInfo(path.Base(key(pth)), rec.Size, rec.ModTime, rec.Metadata), false, nil
+}
+
+// Get receive file with given path. Each chunk of the stored version is read
+// in its own transaction into an unlinked temporary file, so a long copy
+// doesn't block writers; ErrObjectChanged is returned if the object is
+// replaced meanwhile.
+func (this *Storage) Get(pth string) (file *os.File, err error) {
+	rec, err := this.get(pth)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	}
+
+	if file, err = ioutil.TempFile("", "oss-bolt"); err != nil {
+		return
+	}
+	os.Remove(file.Name())
+
+	if _, err = io.Copy(file, this.newReader(rec)); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return
+}
+
+// Put store a reader into given path
+func (this *Storage) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return this.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader into given path with metadata
+func (this *Storage) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (_ *oss.Object, err error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	k := key(pth)
+	rec := &record{Version: make([]byte, 16), ChunkSize: this.Config.ChunkSize, ModTime: time.Now()}
+	rand.Read(rec.Version)
+	if !metadata.IsZero() {
+		m := *metadata
+		rec.Metadata = &m
+	}
+	if rec.Metadata == nil || rec.Metadata.ContentType == "" {
+		if contentType := mime.TypeByExtension(path.Ext(k)); contentType != "" {
+			if rec.Metadata == nil {
+				rec.Metadata = &oss.Metadata{}
+			}
+			rec.Metadata.ContentType = contentType
+		}
+	}
+
+	// the reader may be slow, it's copied before the write transactions
+	spool, err := ioutil.TempFile("", "oss-bolt")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(spool.Name())
+	defer spool.Close()
+	if _, err = io.Copy(spool, reader); err != nil {
+		return nil, err
+	}
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if err = this.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).Put(rec.Version, []byte(k))
+	}); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			this.DB.Update(func(tx *bolt.Tx) error {
+				if err := deleteChunks(tx, rec.Version); err != nil {
+					return err
+				}
+				return tx.Bucket(bucketPending).Delete(rec.Version)
+			})
+		}
+	}()
+
+	var (
+		buf = make([]byte, rec.ChunkSize)
+		seq uint32
+		eof bool
+	)
+	for !eof {
+		err = this.DB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(bucketChunks)
+			for written := 0; written < txBytes; {
+				n, err := io.ReadFull(spool, buf)
+				if n > 0 {
+					if seq == 0 && (rec.Metadata == nil || rec.Metadata.ContentType == "") {
+						if rec.Metadata == nil {
+							rec.Metadata = &oss.Metadata{}
+						}
+						rec.Metadata.ContentType = http.DetectContentType(buf[:n])
+					}
+					if err := bucket.Put(chunkKey(rec.Version, seq), append([]byte{}, buf[:n]...)); err != nil {
+						return err
+					}
+					seq++
+					written += n
+					rec.Size += int64(n)
+				}
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					eof = true
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if err = this.DB.Update(func(tx *bolt.Tx) error {
+		objects := tx.Bucket(bucketObjects)
+		if old := objects.Get([]byte(k)); old != nil {
+			var oldRec record
+			if err := json.Unmarshal(old, &oldRec); err != nil {
+				return err
+			}
+			if err := deleteChunks(tx, oldRec.Version); err != nil {
+				return err
+			}
+		}
+		if err := objects.Put([]byte(k), data); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketPending).Delete(rec.Version)
+	}); err != nil {
+		return nil, err
+	}
+	return this.object(k, rec), nil
+}
+
+func (this *Storage) object(k string, rec *record) *oss.Object {
+	modTime := rec.ModTime
+	return &oss.Object{
+		Path:             "/" + k,
+		Name:             path.Base(k),
+		LastModified:     &modTime,
+		Metadata:         rec.Metadata,
+		StorageInterface: this,
+	}
+}
+
+// Delete delete file
+func (this *Storage) Delete(pth string) error {
+	k := []byte(key(pth))
+	return this.DB.Update(func(tx *bolt.Tx) error {
+		objects := tx.Bucket(bucketObjects)
+		data := objects.Get(k)
+		if data == nil {
+			return &os.PathError{Op: "remove", Path: pth, Err: os.ErrNotExist}
+		}
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		if err := deleteChunks(tx, rec.Version); err != nil {
+			return err
+		}
+		return objects.Delete(k)
+	})
+}
+
+// List list all objects under current path, seeking the cursor to the
+// prefix.
+func (this *Storage) List(pth string) (objects []*oss.Object, err error) {
+	prefix := key(pth)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	err = this.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketObjects).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			objects = append(objects, this.object(string(k), &rec))
+		}
+		return nil
+	})
+	return
+}
+
+// GetEndpoint get endpoint
+func (this *Storage) GetEndpoint() *oss.Endpoint {
+	return &this.Endpoint
+}
+
+func (this *Storage) GetURL(p ...string) (url string) {
+	url = this.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (this *Storage) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = this.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+// AssetFS exports the assets stored in the bucket, see oss.AssetExport.
+func (this *Storage) AssetFS() (assetfs.Interface, error) {
+	return this.assets.AssetFS(this)
+}
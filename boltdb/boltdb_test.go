@@ -0,0 +1,156 @@
+package boltdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/tests"
+)
+
+func newTestStorage(t *testing.T, cfg *Config) *Storage {
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "oss.db")
+	}
+	storage, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func countChunks(t *testing.T, storage *Storage) (n int) {
+	storage.DB.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketChunks).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newTestStorage(t, &Config{}), t)
+}
+
+func TestChunks(t *testing.T) {
+	storage := newTestStorage(t, &Config{ChunkSize: 4})
+	metadata := &oss.Metadata{ContentDisposition: "attachment", Custom: map[string]string{"k": "v"}}
+	if _, err := storage.PutWithMetadata("a/b.txt", strings.NewReader("0123456789"), metadata); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(t, storage); n != 3 {
+		t.Errorf("expected 3 chunks, got %v", n)
+	}
+
+	info, notFound, err := storage.Stat("/a/b.txt")
+	m := oss.GetMetadata(info)
+	if err != nil || notFound || info.Size() != 10 || !strings.HasPrefix(m.ContentType, "text/plain") || m.Custom["k"] != "v" {
+		t.Errorf("bad stat %v %v %+v", notFound, err, m)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/a/b.txt", nil)
+	req.Header.Set("Range", "bytes=3-8")
+	storage.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "345678" || w.Header().Get("Content-Disposition") != "attachment" {
+		t.Errorf("bad response %v %q", w.Code, w.Body.String())
+	}
+
+	if _, err = storage.Put("a/b.txt", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(t, storage); n != 1 {
+		t.Errorf("expected 1 chunk, got %v", n)
+	}
+	file, err := storage.Get("a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "new" {
+		t.Errorf("bad content %q", data)
+	}
+
+	if err = storage.Delete("a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(t, storage); n != 0 {
+		t.Errorf("expected no chunks, got %v", n)
+	}
+}
+
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("read failed")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestInterruptedWrites(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "oss.db")
+	storage, err := Open(&Config{Path: pth, ChunkSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.Put("a", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed write keeps the previous object
+	if _, err = storage.Put("a", &failingReader{"0123456789"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := countChunks(t, storage); n != 1 {
+		t.Errorf("expected 1 chunk, got %v", n)
+	}
+
+	// simulate a crash in the middle of a write
+	storage.DB.Update(func(tx *bolt.Tx) error {
+		version := []byte("0123456789abcdef")
+		tx.Bucket(bucketPending).Put(version, []byte("a"))
+		return tx.Bucket(bucketChunks).Put(chunkKey(version, 0), []byte("part"))
+	})
+	storage.Close()
+
+	storage = newTestStorage(t, &Config{Path: pth})
+	if n := countChunks(t, storage); n != 1 {
+		t.Errorf("expected the orphan chunks removed, got %v chunks", n)
+	}
+	file, err := storage.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "old" {
+		t.Errorf("bad content %q", data)
+	}
+}
+
+func TestList(t *testing.T) {
+	storage := newTestStorage(t, &Config{})
+	for _, name := range []string{"a/1", "a/b/2", "ab/3", "b/4"} {
+		if _, err := storage.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := storage.List("/a")
+	if err != nil || len(objects) != 2 || objects[0].Path != "/a/1" || objects[1].Path != "/a/b/2" {
+		t.Errorf("bad list %v %v", objects, err)
+	}
+	if objects, _ = storage.List(""); len(objects) != 4 {
+		t.Errorf("bad list %v", objects)
+	}
+}
@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+)
+
+type Config struct {
+	// Parent directory of the cache directory. Default is the temporary
+	// directory.
+	Dir string
+	// value in bytes. Least recently used files are removed above it. Zero
+	// is unlimited.
+	MaxSize int64
+	// value in seconds. Cached files younger than it are used without
+	// revalidation, older ones are revalidated with Stat. Zero revalidates
+	// on every Get.
+	TTL int64
+}
+
+var _ oss.MetadataStorageInterface = (*Storage)(nil)
+
+type entry struct {
+	key         string
+	file        string
+	size        int64
+	validator   string
+	validatedAt time.Time
+}
+
+// call is an in flight load of a key, shared by the concurrent misses.
+type call struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+// Storage keeps the files got from Backend on local disk. Entries are
+// revalidated against the ETag, or the modification time and size, of the
+// Stat of the backend, and invalidated by Put and Delete.
+type Storage struct {
+	Config  Config
+	Backend oss.StorageInterface
+	Dir     string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	calls   map[string]*call
+	gens    map[string]uint64 // invalidations of the paths being loaded
+}
+
+// New initialize the cache of backend in a new directory under Config.Dir.
+func New(cfg *Config, backend oss.StorageInterface) (*Storage, error) {
+	dir, err := ioutil.TempDir(cfg.Dir, "oss-cache")
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{
+		Config:  *cfg,
+		Backend: backend,
+		Dir:     dir,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		calls:   map[string]*call{},
+		gens:    map[string]uint64{},
+	}, nil
+}
+
+// Close removes the cache directory.
+func (this *Storage) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.entries, this.lru, this.size = map[string]*list.Element{}, list.New(), 0
+	return os.RemoveAll(this.Dir)
+}
+
+func key(pth string) string {
+	return strings.Trim(path.Clean("/"+pth), "/")
+}
+
+// validator returns the ETag of info, or its modification time and size.
+func validator(info os.FileInfo) string {
+	if e, ok := info.(interface{ ETag() string }); ok && e.ETag() != "" {
+		return "etag:" + e.ETag()
+	}
+	return "mtime:" + info.ModTime().UTC().Format(time.RFC3339Nano) + ":" + strconv.FormatInt(info.Size(), 10)
+}
+
+// Usage returns the bytes and the number of the cached files.
+func (this *Storage) Usage() (size int64, files int) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.size, this.lru.Len()
+}
+
+// Invalidate removes the cached file of path.
+func (this *Storage) Invalidate(pth string) {
+	k := key(pth)
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.calls[k] != nil {
+		this.gens[k]++
+	}
+	if el := this.entries[k]; el != nil {
+		this.remove(el)
+	}
+}
+
+// remove removes the entry of el, this.mu must be held.
+func (this *Storage) remove(el *list.Element) {
+	e := this.lru.Remove(el).(*entry)
+	delete(this.entries, e.key)
+	this.size -= e.size
+	os.Remove(e.file)
+}
+
+// open opens the cached file of k if it is fresh, this.mu must be held.
+func (this *Storage) open(k string, fresh bool) (*os.File, *entry) {
+	el := this.entries[k]
+	if el == nil {
+		return nil, nil
+	}
+	e := el.Value.(*entry)
+	if fresh && time.Since(e.validatedAt) >= time.Duration(this.Config.TTL)*time.Second {
+		return nil, e
+	}
+	f, err := os.Open(e.file)
+	if err != nil {
+		this.remove(el)
+		return nil, nil
+	}
+	this.lru.MoveToFront(el)
+	return f, e
+}
+
+// Get receive file with given path from the cache, downloading it from the
+// backend on a miss. Concurrent misses of the same path download it once.
+func (this *Storage) Get(pth string) (*os.File, error) {
+	k := key(pth)
+	for {
+		this.mu.Lock()
+		if f, _ := this.open(k, true); f != nil {
+			this.mu.Unlock()
+			return f, nil
+		}
+		if c := this.calls[k]; c != nil {
+			this.mu.Unlock()
+			c.wg.Wait()
+			if c.err != nil {
+				return nil, c.err
+			}
+		} else {
+			c = &call{}
+			c.wg.Add(1)
+			this.calls[k] = c
+			this.mu.Unlock()
+
+			c.err = this.load(k, pth)
+
+			this.mu.Lock()
+			delete(this.calls, k)
+			delete(this.gens, k)
+			this.mu.Unlock()
+			c.wg.Done()
+			if c.err != nil {
+				return nil, c.err
+			}
+		}
+
+		this.mu.Lock()
+		f, _ := this.open(k, false)
+		this.mu.Unlock()
+		if f != nil {
+			return f, nil
+		}
+		// evicted or invalidated meanwhile, load it again
+	}
+}
+
+// load revalidates or downloads the file of k.
+func (this *Storage) load(k, pth string) error {
+	info, notFound, err := this.Backend.Stat(pth)
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	gen := this.gens[k]
+	if notFound {
+		if el := this.entries[k]; el != nil {
+			this.remove(el)
+		}
+		this.mu.Unlock()
+		return &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	}
+	v := validator(info)
+	if el := this.entries[k]; el != nil {
+		if e := el.Value.(*entry); e.validator == v {
+			e.validatedAt = time.Now()
+			this.mu.Unlock()
+			return nil
+		}
+		this.remove(el)
+	}
+	this.mu.Unlock()
+
+	src, err := this.Backend.Get(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		src.Close()
+		oss.RemoveSpool(src)
+	}()
+
+	tmp, err := ioutil.TempFile(this.Dir, ".download")
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	sum := sha1.Sum([]byte(k))
+	e := &entry{key: k, file: filepath.Join(this.Dir, hex.EncodeToString(sum[:])), size: size, validator: v, validatedAt: time.Now()}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.gens[k] != gen {
+		// invalidated while downloading, the file may be stale
+		os.Remove(tmp.Name())
+		return nil
+	}
+	if err = os.Rename(tmp.Name(), e.file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	this.entries[k] = this.lru.PushFront(e)
+	this.size += size
+	this.evict(e)
+	return nil
+}
+
+// evict removes the least recently used files above MaxSize, keeping
+// current. this.mu must be held.
+func (this *Storage) evict(current *entry) {
+	if this.Config.MaxSize <= 0 {
+		return
+	}
+	for el := this.lru.Back(); el != nil && this.size > this.Config.MaxSize; {
+		prev := el.Prev()
+		if el.Value.(*entry) != current {
+			this.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (this *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.Backend.ServeHTTP(w, r)
+}
+
+func (this *Storage) Stat(pth string) (os.FileInfo, bool, error) {
+	return this.Backend.Stat(pth)
+}
+
+// Put store a reader into the backend and invalidates the cached file.
+func (this *Storage) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return this.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader with metadata into the backend and
+// invalidates the cached file.
+func (this *Storage) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	this.Invalidate(pth)
+	object, err := oss.PutWithMetadata(this.Backend, pth, reader, metadata)
+	this.Invalidate(pth)
+	if err != nil {
+		return nil, err
+	}
+	result := *object
+	result.StorageInterface = this
+	return &result, nil
+}
+
+// Delete delete file from the backend and invalidates the cached file.
+func (this *Storage) Delete(pth string) error {
+	err := this.Backend.Delete(pth)
+	this.Invalidate(pth)
+	return err
+}
+
+func (this *Storage) List(pth string) ([]*oss.Object, error) {
+	objects, err := this.Backend.List(pth)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*oss.Object, len(objects))
+	for i, object := range objects {
+		o := *object
+		o.StorageInterface = this
+		result[i] = &o
+	}
+	return result, nil
+}
+
+func (this *Storage) GetEndpoint() *oss.Endpoint {
+	return this.Backend.GetEndpoint()
+}
+
+func (this *Storage) GetURL(p ...string) string {
+	return this.Backend.GetURL(p...)
+}
+
+func (this *Storage) GetDynamicURL(scheme, host string, p ...string) string {
+	return this.Backend.GetDynamicURL(scheme, host, p...)
+}
+
+func (this *Storage) AssetFS() (assetfs.Interface, error) {
+	return this.Backend.AssetFS()
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ecletus/oss/memory"
+	"github.com/ecletus/oss/tests"
+)
+
+type counter struct {
+	gets, stats int32
+}
+
+func newTestStorage(t *testing.T, cfg *Config, latency int64) (*Storage, *memory.Storage, *counter) {
+	c := &counter{}
+	backend := memory.New(&memory.Config{Latency: latency, Fail: func(op, path string) error {
+		switch op {
+		case "Get":
+			atomic.AddInt32(&c.gets, 1)
+		case "Stat":
+			atomic.AddInt32(&c.stats, 1)
+		}
+		return nil
+	}})
+	cfg.Dir = t.TempDir()
+	storage, err := New(cfg, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage, backend, c
+}
+
+func content(t *testing.T, storage *Storage, pth string) string {
+	file, err := storage.Get(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, _ := ioutil.ReadAll(file)
+	return string(data)
+}
+
+func TestAll(t *testing.T) {
+	storage, _, _ := newTestStorage(t, &Config{}, 0)
+	tests.TestAll(storage, t)
+}
+
+func TestRevalidation(t *testing.T) {
+	storage, backend, c := newTestStorage(t, &Config{TTL: 3600}, 0)
+	backend.Put("a.txt", strings.NewReader("v1"))
+
+	for i := 0; i < 3; i++ {
+		if got := content(t, storage, "a.txt"); got != "v1" {
+			t.Errorf("bad content %q", got)
+		}
+	}
+	if c.gets != 1 || c.stats != 1 {
+		t.Errorf("expected one download and one stat, got %v and %v", c.gets, c.stats)
+	}
+
+	// changed behind the cache, revalidated once the TTL expires
+	backend.Put("a.txt", strings.NewReader("v22"))
+	if got := content(t, storage, "a.txt"); got != "v1" {
+		t.Errorf("fresh entry should be used, got %q", got)
+	}
+	storage.Config.TTL = 0
+	if got := content(t, storage, "a.txt"); got != "v22" {
+		t.Errorf("bad content %q", got)
+	}
+	if got := content(t, storage, "a.txt"); got != "v22" || c.gets != 2 {
+		t.Errorf("unchanged file should not be downloaded again, got %q after %v downloads", got, c.gets)
+	}
+
+	backend.Delete("a.txt")
+	if _, err := storage.Get("a.txt"); err == nil {
+		t.Errorf("deleted file should not be served")
+	}
+	if size, files := storage.Usage(); size != 0 || files != 0 {
+		t.Errorf("bad usage %v %v", size, files)
+	}
+}
+
+func TestInvalidation(t *testing.T) {
+	storage, _, c := newTestStorage(t, &Config{TTL: 3600}, 0)
+	storage.Put("a.txt", strings.NewReader("v1"))
+	if got := content(t, storage, "a.txt"); got != "v1" {
+		t.Errorf("bad content %q", got)
+	}
+
+	storage.Put("a.txt", strings.NewReader("v2"))
+	if got := content(t, storage, "a.txt"); got != "v2" || c.gets != 2 {
+		t.Errorf("put should invalidate, got %q after %v downloads", got, c.gets)
+	}
+
+	if err := storage.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get("a.txt"); err == nil {
+		t.Errorf("delete should invalidate")
+	}
+	if len(storage.gens) != 0 {
+		t.Errorf("generations kept without loads: %v", storage.gens)
+	}
+}
+
+func TestConcurrentMisses(t *testing.T) {
+	storage, backend, c := newTestStorage(t, &Config{TTL: 3600}, 20)
+	backend.Put("a.txt", strings.NewReader("content"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := content(t, storage, "a.txt"); got != "content" {
+				t.Errorf("bad content %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.gets != 1 {
+		t.Errorf("expected one download, got %v", c.gets)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	storage, backend, c := newTestStorage(t, &Config{TTL: 3600, MaxSize: 10}, 0)
+	for _, name := range []string{"a", "b", "c"} {
+		backend.Put(name, strings.NewReader(name+name+name+name))
+	}
+
+	content(t, storage, "a")
+	content(t, storage, "b")
+	content(t, storage, "a")
+	content(t, storage, "c")
+	if size, files := storage.Usage(); size != 8 || files != 2 {
+		t.Errorf("bad usage %v %v", size, files)
+	}
+
+	// b was the least recently used
+	content(t, storage, "a")
+	content(t, storage, "c")
+	if c.gets != 3 {
+		t.Errorf("expected 3 downloads, got %v", c.gets)
+	}
+	content(t, storage, "b")
+	if c.gets != 4 {
+		t.Errorf("expected 4 downloads, got %v", c.gets)
+	}
+}
+
+func TestRemovesSpools(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	backend := memory.New(&memory.Config{})
+	backend.Put("a.txt", strings.NewReader("a"))
+	storage, err := New(&Config{Dir: t.TempDir()}, tests.SpoolStorage{StorageInterface: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	if got := content(t, storage, "a.txt"); got != "a" {
+		t.Errorf("bad content %q", got)
+	}
+	if spools, _ := filepath.Glob(filepath.Join(tmp, "*")); len(spools) != 0 {
+		t.Errorf("spools not removed: %v", spools)
+	}
+}
@@ -11,3 +11,11 @@ var ErrAssetFsUnavailable = errors.New("asset fs unavailable")
 func IsErrAssetFsUnavailable(err error) bool {
 	return error_utils.IsError(ErrAssetFsUnavailable, err)
 }
+
+// ErrReadOnly is returned by the write operations of read only storages,
+// wrapped in an *os.PathError with the operation and path.
+var ErrReadOnly = errors.New("read only storage")
+
+func IsErrReadOnly(err error) bool {
+	return errors.Is(err, ErrReadOnly)
+}
@@ -8,10 +8,11 @@ import (
 	"github.com/pkg/errors"
 
 	"io"
-	"net/http"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/ecletus/helpers"
 
@@ -19,17 +20,20 @@ import (
 
 	errwrap "github.com/moisespsena-go/error-wrap"
 
-	path_helpers "github.com/moisespsena-go/path-helpers"
-
 	"github.com/ecletus/oss"
 )
 
 func init() {
 	factories.Registry("fs", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
 		var cfg Config
+		modes, config, err := parseModes(config)
+		if err != nil {
+			return nil, err
+		}
 		if err = helpers.ParseMap(config, &cfg); err != nil {
 			return nil, err
 		}
+		cfg.FileMode, cfg.DirMode, cfg.Umask = modes.FileMode, modes.DirMode, modes.Umask
 		if ctx.Var != nil {
 			ctx.Var.FormatPathPtr(&cfg.RootDir)
 			if cfg.Endpoint != nil {
@@ -43,12 +47,59 @@ func init() {
 type Config struct {
 	RootDir  string
 	Endpoint *oss.Endpoint
+	// SyncDir fsyncs the parent directory after a file is renamed into place,
+	// so the new directory entry survives a crash.
+	SyncDir bool
+	// Sandbox makes sure every path, including symlink targets, stays under
+	// RootDir. Other paths fail with OutsideRootError.
+	Sandbox bool
+	HTTP    HTTPConfig
+	// Metadata storage mode: "" (xattr with sidecar fallback), "xattr",
+	// "sidecar" or "none". The sidecar of "a/b" is "a/.b.meta.json"; while
+	// "a/b" exists that path is reserved and hidden from List.
+	Metadata string
+	// Mode of new files and directories. Nil resolves them from the parent
+	// directory. Factory configs accept octal strings like "0640".
+	FileMode *os.FileMode
+	DirMode  *os.FileMode
+	// Permission bits cleared from the modes of new files and directories.
+	Umask os.FileMode
+	// Owner of new files and directories. Nil keeps the process ones.
+	Uid *int
+	Gid *int
+	// Skip the entries that can't be read in List instead of failing.
+	ListSkipErrors bool
+	// Store files in hash-prefix directories. See HashLayout.
+	Sharding *HashLayout
+	// Maximum bytes and number of files stored. Zero means no limit.
+	QuotaBytes int64
+	QuotaFiles int64
 }
 
 // FileSystem file system storage
 type FileSystem struct {
 	Base     string
 	Endpoint oss.Endpoint
+	SyncDir  bool
+	Sandbox  bool
+	HTTP     HTTPConfig
+	Metadata string
+	FileMode *os.FileMode
+	DirMode  *os.FileMode
+	Umask    os.FileMode
+	Uid      *int
+	Gid      *int
+	// Skip the entries that can't be read in List instead of failing.
+	ListSkipErrors bool
+	// Layout maps logical paths to physical ones. Nil stores files at their
+	// logical paths.
+	Layout Layout
+	// Maximum bytes and number of files stored. Zero means no limit.
+	QuotaBytes int64
+	QuotaFiles int64
+	usage      usage
+	uploadsMu  sync.Mutex
+	uploads    map[string]int
 }
 
 // New initialize FileSystem storage
@@ -66,15 +117,31 @@ func New(cfg *Config) *FileSystem {
 	if cfg.Endpoint == nil {
 		cfg.Endpoint = &oss.Endpoint{Path: "!"}
 	}
-	return &FileSystem{Base: absbase, Endpoint: *cfg.Endpoint}
-}
-
-func (this *FileSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	pth := this.GetFullPath(r.URL.Path)
-	http.ServeFile(w, r, pth)
+	fs := &FileSystem{
+		Base:     absbase,
+		Endpoint: *cfg.Endpoint,
+		SyncDir:  cfg.SyncDir,
+		Sandbox:  cfg.Sandbox,
+		HTTP:     cfg.HTTP,
+		Metadata: cfg.Metadata,
+		FileMode: cfg.FileMode,
+		DirMode:  cfg.DirMode,
+		Umask:    cfg.Umask,
+		Uid:      cfg.Uid,
+		Gid:      cfg.Gid,
+
+		ListSkipErrors: cfg.ListSkipErrors,
+		QuotaBytes:     cfg.QuotaBytes,
+		QuotaFiles:     cfg.QuotaFiles,
+	}
+	if cfg.Sharding != nil {
+		fs.Layout = cfg.Sharding
+	}
+	return fs
 }
 
-// GetFullPath get full path from absolute/relative path
+// GetFullPath get full path from absolute/relative path. It doesn't apply the
+// Layout and the sandbox checks, use ResolvePath for it.
 func (this *FileSystem) GetFullPath(path string) string {
 	fullpath := path
 	if !strings.HasPrefix(path, this.Base) {
@@ -84,85 +151,228 @@ func (this *FileSystem) GetFullPath(path string) string {
 }
 
 func (this *FileSystem) Stat(path string) (info os.FileInfo, notFound bool, err error) {
-	info, err = os.Stat(this.GetFullPath(path))
-	if err != nil && os.IsNotExist(err) {
-		return nil, true, nil
+	var fullpath string
+	if fullpath, info, err = this.lookup(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, true, nil
+		}
+		return
+	}
+	if !info.IsDir() {
+		var metadata *oss.Metadata
+		if metadata, err = this.readMetadata(fullpath); err != nil {
+			return nil, false, err
+		}
+		info = &fileInfo{info, metadata}
 	}
 	return
 }
 
 // Get receive file with given path
 func (this *FileSystem) Get(path string) (*os.File, error) {
-	return os.Open(this.GetFullPath(path))
+	fullpath, _, err := this.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fullpath)
 }
 
 // Put store a reader into given path
 func (this *FileSystem) Put(path string, reader io.Reader) (*oss.Object, error) {
-	var (
-		fullpath      = this.GetFullPath(path)
-		base          = filepath.Dir(fullpath)
-		baseMode, err = path_helpers.ResolveMode(base)
-		fileMode      os.FileMode
-	)
+	return this.PutWithMetadata(path, reader, nil)
+}
 
+// PutWithMetadata store a reader into given path with metadata
+func (this *FileSystem) PutWithMetadata(path string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	fullpath, err := this.ResolvePath(path)
 	if err != nil {
-		return nil, errwrap.Wrap(err, "Resolve mode of %q", base)
-	}
-
-	if err = os.MkdirAll(base, baseMode); err != nil {
-		return nil, errwrap.Wrap(err, "Create base directory %q", base)
+		return nil, err
 	}
 
-	if fileMode, err = path_helpers.ResolveFileMode(fullpath); err != nil {
-		return nil, errwrap.Wrap(err, "Resolve mode of %q", fullpath)
+	if err = this.mkdirAll(filepath.Dir(fullpath)); err != nil {
+		return nil, err
 	}
 
-	dst, err := os.OpenFile(fullpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode)
+	fileMode, err := this.fileMode(fullpath)
 	if err != nil {
-		return nil, errwrap.Wrap(err, "Create file %q", fullpath)
+		return nil, err
 	}
-	defer dst.Close()
 
 	if seeker, ok := reader.(io.ReadSeeker); ok {
 		seeker.Seek(0, 0)
 	}
-	if _, err = io.Copy(dst, reader); err != nil {
+
+	if metadata.IsZero() {
+		metadata = nil
+	}
+
+	if this.hasQuota() {
+		var (
+			oldSize int64
+			newFile = true
+			qr      *quotaReader
+		)
+		if info, err := os.Stat(fullpath); err == nil {
+			oldSize, newFile = info.Size(), false
+		}
+		if qr, err = this.newQuotaReader(reader, oldSize, newFile); err != nil {
+			return nil, err
+		}
+		err = this.writeFile(fullpath, qr, fileMode, metadata)
+		qr.done()
+		if qr.err != nil {
+			return nil, qr.err
+		}
+	} else {
+		err = this.writeFile(fullpath, reader, fileMode, metadata)
+	}
+
+	if err != nil {
 		return nil, err
 	}
 
-	return &oss.Object{Path: path, Name: filepath.Base(path), StorageInterface: this}, err
-}
+	if err = this.removeFlat(path, fullpath); err != nil {
+		return nil, err
+	}
 
-// Delete delete file
-func (this FileSystem) Delete(path string) error {
-	return os.Remove(this.GetFullPath(path))
+	return &oss.Object{Path: path, Name: filepath.Base(path), Metadata: metadata, StorageInterface: this}, err
 }
 
-// List list all objects under current path
-func (this *FileSystem) List(path string) ([]*oss.Object, error) {
-	var (
-		objects  []*oss.Object
-		fullpath = this.GetFullPath(path)
-	)
+// writeFile writes reader into a temporary file in the directory of fullpath,
+// syncs it and renames it into place, so readers never see a partial file.
+// The metadata sidecar file, if any, is written before the rename so the file
+// is never visible without its metadata.
+func (this *FileSystem) writeFile(fullpath string, reader io.Reader, mode os.FileMode, metadata *oss.Metadata) (err error) {
+	dir := filepath.Dir(fullpath)
 
-	filepath.Walk(fullpath, func(path string, info os.FileInfo, err error) error {
-		if path == fullpath {
-			return nil
+	tmp, err := ioutil.TempFile(dir, tempPrefix+"*")
+	if err != nil {
+		return errwrap.Wrap(err, "Create temporary file in %q", dir)
+	}
+
+	this.beginUpload(fullpath)
+	defer this.endUpload(fullpath)
+
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
 		}
+	}()
+
+	if err = tmp.Chmod(mode); err != nil {
+		return errwrap.Wrap(err, "Chmod %q", tmp.Name())
+	}
+	if err = this.chown(tmp.Name()); err != nil {
+		return
+	}
+	size, err := io.Copy(tmp, reader)
+	if err != nil {
+		return errwrap.Wrap(err, "Write %q", tmp.Name())
+	}
+	if err = tmp.Sync(); err != nil {
+		return errwrap.Wrap(err, "Sync %q", tmp.Name())
+	}
+	if err = tmp.Close(); err != nil {
+		return errwrap.Wrap(err, "Close %q", tmp.Name())
+	}
+
+	var sidecar bool
+	if sidecar, err = this.setXattrMetadata(tmp.Name(), metadata); err != nil {
+		return
+	}
+	if !sidecar {
+		metadata = nil
+	}
+	if err = this.setSidecarMetadata(fullpath, metadata); err != nil {
+		return
+	}
+	if err = this.replace(tmp.Name(), fullpath, size); err != nil {
+		return errwrap.Wrap(err, "Rename %q to %q", tmp.Name(), fullpath)
+	}
 
-		if err == nil && !info.IsDir() {
-			modTime := info.ModTime()
-			objects = append(objects, &oss.Object{
-				Path:             strings.TrimPrefix(path, this.Base),
-				Name:             info.Name(),
-				LastModified:     &modTime,
-				StorageInterface: this,
-			})
+	if this.SyncDir {
+		if err = syncDir(dir); err != nil {
+			return errwrap.Wrap(err, "Sync directory %q", dir)
 		}
-		return nil
-	})
+	}
+	return nil
+}
+
+func (this *FileSystem) beginUpload(fullpath string) {
+	this.uploadsMu.Lock()
+	defer this.uploadsMu.Unlock()
+	if this.uploads == nil {
+		this.uploads = map[string]int{}
+	}
+	this.uploads[fullpath]++
+}
+
+func (this *FileSystem) endUpload(fullpath string) {
+	this.uploadsMu.Lock()
+	defer this.uploadsMu.Unlock()
+	if this.uploads[fullpath]--; this.uploads[fullpath] <= 0 {
+		delete(this.uploads, fullpath)
+	}
+}
+
+// isUploading reports whether a Put of fullpath is in progress.
+func (this *FileSystem) isUploading(fullpath string) bool {
+	this.uploadsMu.Lock()
+	defer this.uploadsMu.Unlock()
+	return this.uploads[fullpath] > 0
+}
 
-	return objects, nil
+// tempPrefix starts the names of the temporary files of Put. Paths named
+// like them are reserved.
+const tempPrefix = ".oss-tmp-"
+
+// isTemp reports whether name is the name of a temporary file of Put, which
+// are hidden from List.
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, tempPrefix)
+}
+
+// ReservedPathError is returned for the paths reserved by the storage: the
+// temporary files of Put and the metadata sidecar files of other files.
+type ReservedPathError struct {
+	Path string
+}
+
+func (e *ReservedPathError) Error() string {
+	return fmt.Sprintf("filesystem: path %q is reserved", e.Path)
+}
+
+func IsReservedPathError(err error) bool {
+	_, ok := err.(*ReservedPathError)
+	return ok
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Delete delete file
+func (this *FileSystem) Delete(path string) error {
+	fullpath, info, err := this.lookup(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return os.Remove(fullpath)
+	}
+	if err = this.removeFile(fullpath); err != nil {
+		return err
+	}
+	if err = this.setSidecarMetadata(fullpath, nil); err != nil {
+		return err
+	}
+	return this.removeFlat(path, fullpath)
 }
 
 // GetEndpoint get Endpoint, FileSystem's Endpoint is /
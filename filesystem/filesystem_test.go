@@ -1,12 +1,612 @@
 package filesystem
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ecletus/oss"
 	"github.com/ecletus/oss/tests"
 )
 
+func newTestFileSystem(t *testing.T, cfg *Config) *FileSystem {
+	dir, err := ioutil.TempDir("", "oss-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	cfg.RootDir = dir
+	return New(cfg)
+}
+
 func TestAll(t *testing.T) {
-	fileSystem := New("/tmp")
+	fileSystem := newTestFileSystem(t, &Config{})
 	tests.TestAll(fileSystem, t)
 }
+
+func TestPutReplacesAtomically(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{SyncDir: true})
+
+	for _, data := range []string{"first", "second"} {
+		if _, err := fileSystem.Put("a/b.txt", strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(fileSystem.GetFullPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "b.txt" {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	if data, err := ioutil.ReadFile(fileSystem.GetFullPath("a/b.txt")); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "second" {
+		t.Errorf("bad content %q", data)
+	}
+}
+
+func TestTempNames(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{})
+
+	if _, err := fileSystem.Put("a/"+tempPrefix+"x", strings.NewReader("x")); !IsReservedPathError(err) {
+		t.Errorf("Put: expected ReservedPathError, got %v", err)
+	}
+	// named like the temporary files of other tools
+	if _, err := fileSystem.Put("a/.b.txt.123.tmp", strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileSystem.GetFullPath("a/"+tempPrefix+"123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := fileSystem.List("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Path != "/a/.b.txt.123.tmp" {
+		t.Errorf("bad list %v", objects)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{Sandbox: true})
+
+	outside, err := ioutil.TempDir("", "oss-fs-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err = ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(outside, fileSystem.GetFullPath("link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pth := range []string{"../../etc/passwd", "a/../../b", "link/secret", "/link/new"} {
+		if _, err := fileSystem.Get(pth); !IsOutsideRootError(err) {
+			t.Errorf("Get(%q): expected OutsideRootError, got %v", pth, err)
+		}
+		if _, err := fileSystem.Put(pth, strings.NewReader("x")); !IsOutsideRootError(err) {
+			t.Errorf("Put(%q): expected OutsideRootError, got %v", pth, err)
+		}
+	}
+
+	if _, err := fileSystem.Put("a/b.txt", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.Get(fileSystem.GetFullPath("a/b.txt")); err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	fileSystem.ServeHTTP(w, httptest.NewRequest("GET", "/link/secret", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP: expected 404, got %d", w.Code)
+	}
+
+	// a precompressed sibling must not lead out of the root either
+	fileSystem.HTTP.Precompressed = true
+	if err = os.Symlink(filepath.Join(outside, "secret"), fileSystem.GetFullPath("a/b.txt.gz")); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/a/b.txt", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	fileSystem.ServeHTTP(w, r)
+	if w.Body.String() != "x" || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("ServeHTTP: served the sibling out of the root: %q %v", w.Body.String(), w.Header())
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{HTTP: HTTPConfig{
+		DisableListing: true,
+		Cache:          []CacheRule{{Pattern: "*.css", MaxAge: 3600}, {Pattern: "private/*", CacheControl: "no-store"}},
+		ETag:           true,
+		Attachment:     []string{"application/pdf", ".zip"},
+		Precompressed:  true,
+	}})
+
+	for pth, data := range map[string]string{
+		"a.css":        "body{}",
+		"a.css.gz":     "gzipped",
+		"private/a.js": "js",
+		"doc.pdf":      "pdf",
+	} {
+		if _, err := fileSystem.Put(pth, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serve := func(pth string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", pth, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		fileSystem.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("/private/"); w.Code != http.StatusNotFound {
+		t.Errorf("directory listing: expected 404, got %d", w.Code)
+	}
+
+	if _, err := fileSystem.Put("site/index.html", strings.NewReader("index")); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve("/site"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "site/" {
+		t.Errorf("directory: expected redirect, got %d %v", w.Code, w.Header())
+	}
+	if w := serve("/site/"); w.Body.String() != "index" || w.Header().Get("ETag") == "" {
+		t.Errorf("directory index: bad response %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	w := serve("/a.css")
+	if w.Body.String() != "body{}" || w.Header().Get("Cache-Control") != "public, max-age=3600" || w.Header().Get("Expires") == "" {
+		t.Errorf("a.css: bad response %q %v", w.Body.String(), w.Header())
+	}
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		t.Errorf("a.css: expected strong ETag, got %q", etag)
+	}
+	if w := serve("/a.css", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("a.css: expected 304, got %d", w.Code)
+	}
+	// same size, maybe the same modification time
+	if _, err := fileSystem.Put("a.css", strings.NewReader("body[]")); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve("/a.css"); w.Header().Get("ETag") == etag {
+		t.Errorf("a.css: ETag not changed by Put")
+	}
+
+	w = serve("/a.css", "Accept-Encoding", "br;q=0, gzip")
+	if w.Body.String() != "gzipped" || w.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("a.css: expected gzip sibling, got %q %v", w.Body.String(), w.Header())
+	}
+
+	if w := serve("/private/a.js"); w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("private/a.js: bad Cache-Control %q", w.Header().Get("Cache-Control"))
+	}
+
+	if w := serve("/doc.pdf"); w.Header().Get("Content-Disposition") != `attachment; filename=doc.pdf` {
+		t.Errorf("doc.pdf: bad Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	for _, mode := range []string{MetadataAuto, MetadataSidecar} {
+		fileSystem := newTestFileSystem(t, &Config{Metadata: mode})
+		metadata := &oss.Metadata{
+			ContentType:        "text/x-custom",
+			ContentDisposition: `attachment; filename="b.txt"`,
+			Custom:             map[string]string{"owner": "me"},
+		}
+
+		if _, err := fileSystem.PutWithMetadata("a/b.txt", strings.NewReader("x"), metadata); err != nil {
+			t.Fatal(err)
+		}
+
+		info, _, err := fileSystem.Stat("a/b.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := oss.GetMetadata(info); got == nil || got.ContentType != metadata.ContentType || got.Custom["owner"] != "me" {
+			t.Errorf("%q: bad metadata %+v", mode, got)
+		}
+
+		objects, err := fileSystem.List("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 1 || objects[0].Metadata == nil || objects[0].Metadata.ContentDisposition != metadata.ContentDisposition {
+			t.Errorf("%q: bad list %v", mode, objects)
+		}
+
+		w := httptest.NewRecorder()
+		fileSystem.ServeHTTP(w, httptest.NewRequest("GET", "/a/b.txt", nil))
+		if w.Header().Get("Content-Type") != metadata.ContentType || w.Header().Get("Content-Disposition") != metadata.ContentDisposition {
+			t.Errorf("%q: bad headers %v", mode, w.Header())
+		}
+
+		if _, err := fileSystem.Put("a/b.txt", strings.NewReader("y")); err != nil {
+			t.Fatal(err)
+		}
+		if info, _, _ := fileSystem.Stat("a/b.txt"); oss.GetMetadata(info) != nil {
+			t.Errorf("%q: metadata should be replaced", mode)
+		}
+	}
+}
+
+func TestSidecarFiles(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{Metadata: MetadataSidecar})
+
+	if _, err := fileSystem.PutWithMetadata("a/b.txt", strings.NewReader("x"), &oss.Metadata{ContentType: "text/x-custom"}); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := fileSystem.GetFullPath("a/.b.txt.meta.json")
+	if _, err := os.Stat(sidecar); err != nil {
+		t.Fatalf("sidecar not written: %v", err)
+	}
+	// an interrupted sidecar write
+	if err := ioutil.WriteFile(fileSystem.GetFullPath("a/"+tempPrefix+"123"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fileSystem.Get("a/.b.txt.meta.json"); !IsReservedPathError(err) {
+		t.Errorf("Get: expected ReservedPathError, got %v", err)
+	}
+	if _, err := fileSystem.Put("a/.b.txt.meta.json", strings.NewReader("{}")); !IsReservedPathError(err) {
+		t.Errorf("Put: expected ReservedPathError, got %v", err)
+	}
+	w := httptest.NewRecorder()
+	fileSystem.ServeHTTP(w, httptest.NewRequest("GET", "/a/.b.txt.meta.json", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP: expected 404, got %d", w.Code)
+	}
+
+	// named like a sidecar, but there is no "c" file
+	if _, err := fileSystem.Put("a/.c.meta.json", strings.NewReader("user")); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := fileSystem.Get("a/.c.meta.json"); err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+
+	objects, err := fileSystem.List("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	if strings.Join(paths, ",") != "/a/.c.meta.json,/a/b.txt" {
+		t.Errorf("bad list %v", paths)
+	}
+
+	w = httptest.NewRecorder()
+	fileSystem.ServeHTTP(w, httptest.NewRequest("GET", "/a/", nil))
+	if body := w.Body.String(); !strings.Contains(body, "b.txt") || !strings.Contains(body, ".c.meta.json") ||
+		strings.Contains(body, ".b.txt.meta.json") || strings.Contains(body, tempPrefix) {
+		t.Errorf("bad listing %q", body)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	fileMode, dirMode := os.FileMode(0666), os.FileMode(0777)
+	fileSystem := newTestFileSystem(t, &Config{FileMode: &fileMode, DirMode: &dirMode, Umask: 0027, Uid: &uid, Gid: &gid})
+
+	if _, err := fileSystem.Put("a/b/c.txt", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	for pth, mode := range map[string]os.FileMode{"a": 0750, "a/b": 0750, "a/b/c.txt": 0640} {
+		if info, err := os.Stat(fileSystem.GetFullPath(pth)); err != nil {
+			t.Error(err)
+		} else if info.Mode().Perm() != mode {
+			t.Errorf("%s: expected mode %o, got %o", pth, mode, info.Mode().Perm())
+		}
+	}
+
+	config := map[string]interface{}{"FileMode": "0", "DirMode": 0750, "RootDir": "dir"}
+	modes, rest, err := parseModes(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modes.FileMode == nil || *modes.FileMode != 0 || modes.DirMode == nil || *modes.DirMode != 0750 || modes.Umask != 0 {
+		t.Errorf("bad parsed modes %+v", modes)
+	}
+	if len(rest) != 1 || rest["RootDir"] != "dir" || config["FileMode"] != "0" {
+		t.Errorf("bad rest %v of config %v", rest, config)
+	}
+	if _, _, err = parseModes(map[string]interface{}{"FileMode": "04755"}); err == nil {
+		t.Error("mode with setuid bit should fail")
+	}
+}
+
+func TestListPage(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{})
+
+	expected := []string{"/d/a", "/d/b/c", "/d/b/d", "/d/b.txt", "/d/c"}
+	for _, pth := range expected {
+		if _, err := fileSystem.Put(pth, strings.NewReader("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		got  []string
+		next string
+	)
+	for i := 0; i < 10; i++ {
+		objects, n, err := fileSystem.ListPage("d", ListOptions{Limit: 2, StartAfter: next})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, object := range objects {
+			got = append(got, object.Path)
+		}
+		if next = n; next == "" {
+			break
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if objects, err := fileSystem.List("missing"); err != nil || len(objects) != 0 {
+		t.Errorf("missing directory: %v %v", objects, err)
+	}
+
+	if os.Getuid() != 0 {
+		if err := os.Chmod(fileSystem.GetFullPath("d/b"), 0); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(fileSystem.GetFullPath("d/b"), 0755)
+
+		if _, err := fileSystem.List("d"); err == nil {
+			t.Error("unreadable directory should fail")
+		}
+		fileSystem.ListSkipErrors = true
+		if objects, err := fileSystem.List("d"); err != nil || len(objects) != 3 {
+			t.Errorf("unreadable directory should be skipped: %v %v", objects, err)
+		}
+	}
+}
+
+func TestHashLayout(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{})
+
+	for _, pth := range []string{"a/b.txt", "a/c/d.txt", "e.txt"} {
+		if _, err := fileSystem.Put(pth, strings.NewReader(pth)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	layout := &HashLayout{}
+	fileSystem.Layout = layout
+
+	// not migrated yet
+	if f, err := fileSystem.Get("a/b.txt"); err != nil {
+		t.Errorf("Get before migration: %v", err)
+	} else {
+		f.Close()
+	}
+	if objects, err := fileSystem.List("a"); err != nil || len(objects) != 2 {
+		t.Errorf("List before migration: %v %v", objects, err)
+	}
+
+	// stored by the layout, the flat copy goes away
+	if _, err := fileSystem.Put("e.txt", strings.NewReader("e.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fileSystem.GetFullPath("e.txt")); !os.IsNotExist(err) {
+		t.Errorf("flat copy should be removed: %v", err)
+	}
+
+	// a newer file stored since the walk and an upload in progress
+	newer := fileSystem.GetFullPath(layout.Physical("a/c/d.txt"))
+	if err := os.MkdirAll(filepath.Dir(newer), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(newer, []byte("a/c/d.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileSystem.GetFullPath("a/c/d.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileSystem.GetFullPath("a/"+tempPrefix+"123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := fileSystem.Migrate(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(fileSystem.GetFullPath("a/" + tempPrefix + "123")); err != nil {
+		t.Errorf("temporary file should be left in place: %v", err)
+	}
+	if _, err := os.Stat(fileSystem.GetFullPath("a/c/d.txt")); !os.IsNotExist(err) {
+		t.Errorf("outdated flat copy should be removed: %v", err)
+	}
+
+	if physical := layout.Physical("a/b.txt"); len(strings.Split(physical, "/")) != 4 {
+		t.Errorf("bad physical path %q", physical)
+	} else if _, err := os.Stat(fileSystem.GetFullPath(physical)); err != nil {
+		t.Errorf("file not migrated: %v", err)
+	}
+	if _, err := os.Stat(fileSystem.GetFullPath("a/c")); err != nil {
+		t.Errorf("logical directory should be kept: %v", err)
+	}
+
+	if _, err := fileSystem.Put("a/f.txt", strings.NewReader("a/f.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := fileSystem.List("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	if len(paths) != 3 || !strings.Contains(strings.Join(paths, ","), "/a/c/d.txt") {
+		t.Errorf("bad list %v", paths)
+	}
+
+	if _, notFound, err := fileSystem.Stat("e.txt"); notFound || err != nil {
+		t.Errorf("Stat: %v %v", notFound, err)
+	}
+
+	w := httptest.NewRecorder()
+	fileSystem.ServeHTTP(w, httptest.NewRequest("GET", "/a/c/d.txt", nil))
+	if w.Body.String() != "a/c/d.txt" {
+		t.Errorf("ServeHTTP: bad body %q", w.Body.String())
+	}
+
+	if _, err := fileSystem.Put("a/index.html", strings.NewReader("index")); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	fileSystem.ServeHTTP(w, httptest.NewRequest("GET", "/a/", nil))
+	if w.Body.String() != "index" {
+		t.Errorf("ServeHTTP directory: bad response %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	fileSystem.ServeHTTP(w, httptest.NewRequest("GET", "/a/c/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP directory listing: expected 404, got %d", w.Code)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{QuotaBytes: 10, QuotaFiles: 2})
+
+	if _, err := fileSystem.Put("a", strings.NewReader("12345")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.Put("b", strings.NewReader("123456")); !IsQuotaExceededError(err) {
+		t.Errorf("expected bytes quota error, got %v", err)
+	}
+	if _, err := os.Stat(fileSystem.GetFullPath("b")); !os.IsNotExist(err) {
+		t.Error("file exceeding the quota should not be stored")
+	}
+	if _, err := fileSystem.Put("a", strings.NewReader("1234567890")); err != nil {
+		t.Errorf("replacing a file should release its size: %v", err)
+	}
+	if err := fileSystem.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pth := range []string{"c", "d"} {
+		if _, err := fileSystem.Put(pth, strings.NewReader("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fileSystem.Put("e", strings.NewReader("1")); !IsQuotaExceededError(err) {
+		t.Errorf("expected files quota error, got %v", err)
+	}
+
+	if bytes, files, err := fileSystem.Usage(); err != nil || bytes != 2 || files != 2 {
+		t.Errorf("bad usage %d %d %v", bytes, files, err)
+	}
+	if err := fileSystem.RecalculateUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes, files, _ := fileSystem.Usage(); bytes != 2 || files != 2 {
+		t.Errorf("bad recalculated usage %d %d", bytes, files)
+	}
+
+	// an interrupted upload
+	if err := ioutil.WriteFile(fileSystem.GetFullPath(tempPrefix+"123"), []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileSystem.RecalculateUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes, files, _ := fileSystem.Usage(); bytes != 2 || files != 2 {
+		t.Errorf("temporary files should not be accounted, got %d %d", bytes, files)
+	}
+}
+
+func TestQuotaConcurrentPuts(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{QuotaBytes: 100})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := fileSystem.Put("a", strings.NewReader("123")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if bytes, files, _ := fileSystem.Usage(); bytes != 3 || files != 1 {
+		t.Errorf("bad usage %d %d", bytes, files)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fileSystem := newTestFileSystem(t, &Config{})
+
+	watcher, err := fileSystem.Watch("/", WatchOptions{Debounce: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	expect := func(typ EventType, pth string) {
+		t.Helper()
+		select {
+		case e := <-watcher.Events:
+			if e.Type != typ || e.Path != pth || e.Object == nil {
+				t.Errorf("expected %v %s, got %v %s", typ, pth, e.Type, e.Path)
+			}
+		case err := <-watcher.Errors:
+			t.Fatal(err)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for %v %s", typ, pth)
+		}
+	}
+
+	if _, err := fileSystem.Put("a.txt", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	expect(Created, "/a.txt")
+
+	if err := os.MkdirAll(fileSystem.GetFullPath("b/c"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fileSystem.GetFullPath("b/c/d.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(Created, "/b/c/d.txt")
+
+	if err := fileSystem.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	expect(Deleted, "/a.txt")
+}
@@ -0,0 +1,300 @@
+package filesystem
+
+import (
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPConfig configures FileSystem.ServeHTTP.
+type HTTPConfig struct {
+	// Respond 404 to directories without index.html instead of listing them.
+	// Directories are never listed when a Layout is set.
+	DisableListing bool
+	// Cache policies. The first rule matching the request path is used.
+	Cache []CacheRule
+	// Send strong ETags made of the size, the modification time and the
+	// inode of the files.
+	ETag bool
+	// Content types (e.g. "application/pdf", "image/*") or extensions (e.g.
+	// ".zip") served with "Content-Disposition: attachment".
+	Attachment []string
+	// Serve the ".br" or ".gz" sibling of a file when the client accepts it.
+	Precompressed bool
+}
+
+// CacheRule sets the cache headers of paths matching Pattern.
+type CacheRule struct {
+	// path.Match pattern of the path relative to the root, without leading
+	// "/". Patterns without "/" match the file name only.
+	Pattern string
+	// value in seconds. Sets "Cache-Control: public, max-age=N" and Expires.
+	MaxAge int64
+	// Cache-Control value, it overrides the one generated from MaxAge.
+	CacheControl string
+}
+
+func (rule *CacheRule) Match(pth string) bool {
+	if !strings.Contains(rule.Pattern, "/") {
+		pth = path.Base(pth)
+	} else {
+		pth = strings.TrimPrefix(pth, "/")
+	}
+	ok, _ := path.Match(rule.Pattern, pth)
+	return ok
+}
+
+var encodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+func (this *FileSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pth, info, err := this.lookup(r.URL.Path)
+	if err != nil {
+		if os.IsNotExist(err) || IsOutsideRootError(err) || IsReservedPathError(err) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if info.IsDir() {
+		this.serveDir(w, r, pth)
+		return
+	}
+
+	this.serveFile(w, r, r.URL.Path, pth, info)
+}
+
+// serveDir serves the index.html of the directory like any other file or, when
+// there is none, the directory listing unless it's disabled.
+func (this *FileSystem) serveDir(w http.ResponseWriter, r *http.Request, pth string) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		// relative links in the index and the listing need the trailing slash.
+		// The location is relative so it works behind http.StripPrefix.
+		target := path.Base(r.URL.Path) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	index := r.URL.Path + "index.html"
+	indexPath, info, err := this.lookup(index)
+	if err == nil && !info.IsDir() {
+		this.serveFile(w, r, index, indexPath, info)
+		return
+	}
+	if err != nil && !os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the physical entries of a Layout aren't storage paths
+	if this.HTTP.DisableListing || this.Layout != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// the listing is served for the directory alone, without its reserved
+	// files
+	r = r.Clone(r.Context())
+	r.URL.Path = "/"
+	http.FileServer(listingDir{this, pth}).ServeHTTP(w, r)
+}
+
+// listingDir is the http.FileSystem of the listing of a directory.
+type listingDir struct {
+	fs  *FileSystem
+	dir string
+}
+
+func (d listingDir) Open(name string) (http.File, error) {
+	if name != "/" {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	return &listingFile{f, d}, nil
+}
+
+// listingFile hides the temporary and the sidecar files from the entries of
+// the directory.
+type listingFile struct {
+	*os.File
+	dir listingDir
+}
+
+func (f *listingFile) hidden(name string) bool {
+	return isTemp(name) || f.dir.fs.isSidecar(filepath.Join(f.dir.dir, name))
+}
+
+func (f *listingFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	entries, err := f.File.ReadDir(count)
+	n := 0
+	for _, entry := range entries {
+		if !f.hidden(entry.Name()) {
+			entries[n] = entry
+			n++
+		}
+	}
+	return entries[:n], err
+}
+
+func (f *listingFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	n := 0
+	for _, info := range infos {
+		if !f.hidden(info.Name()) {
+			infos[n] = info
+			n++
+		}
+	}
+	return infos[:n], err
+}
+
+// serveFile serves the file at pth, requested as urlPath.
+func (this *FileSystem) serveFile(w http.ResponseWriter, r *http.Request, urlPath, pth string, info os.FileInfo) {
+	var (
+		name        = info.Name()
+		header      = w.Header()
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	)
+
+	for _, rule := range this.HTTP.Cache {
+		if rule.Match(urlPath) {
+			if rule.CacheControl != "" {
+				header.Set("Cache-Control", rule.CacheControl)
+			} else {
+				header.Set("Cache-Control", "public, max-age="+strconv.FormatInt(rule.MaxAge, 10))
+			}
+			if rule.MaxAge > 0 {
+				header.Set("Expires", time.Now().Add(time.Duration(rule.MaxAge)*time.Second).UTC().Format(http.TimeFormat))
+			}
+			break
+		}
+	}
+
+	metadata, err := this.readMetadata(pth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if metadata != nil && metadata.ContentType != "" {
+		contentType = metadata.ContentType
+	}
+
+	if metadata != nil && metadata.ContentDisposition != "" {
+		header.Set("Content-Disposition", metadata.ContentDisposition)
+	} else if this.isAttachment(name, contentType) {
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+
+	if this.HTTP.Precompressed {
+		header.Add("Vary", "Accept-Encoding")
+		if encoding, encPath, encInfo := this.precompressed(r, urlPath); encoding != "" {
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			header.Set("Content-Encoding", encoding)
+			pth, info = encPath, encInfo
+		}
+	}
+
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if this.HTTP.ETag {
+		header.Set("ETag", etag(info))
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// precompressed returns the encoding, path and info of the ".br" or ".gz"
+// sibling of urlPath that the client accepts. Siblings are resolved like any
+// requested path, so in sandbox mode a sibling that is a symlink out of the
+// root is never served.
+func (this *FileSystem) precompressed(r *http.Request, urlPath string) (encoding, pth string, info os.FileInfo) {
+	for _, enc := range encodings {
+		if !acceptsEncoding(r, enc.name) {
+			continue
+		}
+		if pth, info, err := this.lookup(urlPath + enc.ext); err == nil && !info.IsDir() {
+			return enc.name, pth, info
+		}
+	}
+	return "", "", nil
+}
+
+func (this *FileSystem) isAttachment(name, contentType string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if i := strings.IndexByte(contentType, ';'); i > 0 {
+		contentType = contentType[:i]
+	}
+
+	for _, pattern := range this.HTTP.Attachment {
+		switch {
+		case strings.HasPrefix(pattern, "."):
+			if strings.ToLower(pattern) == ext {
+				return true
+			}
+		case strings.HasSuffix(pattern, "/*"):
+			if strings.HasPrefix(contentType, pattern[:len(pattern)-1]) {
+				return true
+			}
+		case pattern == contentType:
+			return true
+		}
+	}
+	return false
+}
+
+// etag returns the strong ETag of the file. Put renames a new file into
+// place, so the inode changes with the content even within a clock tick.
+func etag(info os.FileInfo) string {
+	return `"` + strconv.FormatInt(info.Size(), 36) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 36) +
+		"-" + strconv.FormatUint(inode(info), 36) + `"`
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r allows
+// encoding.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(v, ";")
+		if strings.TrimSpace(parts[0]) != encoding {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
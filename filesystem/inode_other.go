@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package filesystem
+
+import "os"
+
+// inode returns zero, the files have no inode number here.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
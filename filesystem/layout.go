@@ -0,0 +1,259 @@
+package filesystem
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	errwrap "github.com/moisespsena-go/error-wrap"
+)
+
+// Layout maps the logical storage paths to the physical paths of the files
+// under Base. Paths are slash separated and relative to Base.
+type Layout interface {
+	Physical(logical string) string
+	// Logical returns the logical path of physical, or false if physical
+	// isn't a path of the layout.
+	Logical(physical string) (logical string, ok bool)
+}
+
+// HashLayout stores each file in Levels nested directories named after the
+// hash of its name, under the logical parent directory:
+// "a/b.txt" is stored as "a/9f/ea/b.txt".
+type HashLayout struct {
+	// Number of directory levels. Default is 2.
+	Levels int
+	// Number of hexadecimal characters of each directory name. Default is 2.
+	Width int
+}
+
+func (l *HashLayout) levels() (levels, width int) {
+	if levels, width = l.Levels, l.Width; levels <= 0 {
+		levels = 2
+	}
+	if width <= 0 {
+		width = 2
+	}
+	if levels*width > md5.Size*2 {
+		levels = md5.Size * 2 / width
+	}
+	return
+}
+
+func (l *HashLayout) shards(name string) []string {
+	levels, width := l.levels()
+	sum := md5.Sum([]byte(name))
+	hash := hex.EncodeToString(sum[:])
+	shards := make([]string, levels)
+	for i := range shards {
+		shards[i] = hash[i*width : (i+1)*width]
+	}
+	return shards
+}
+
+func (l *HashLayout) Physical(logical string) string {
+	dir, name := path.Split(logical)
+	return dir + strings.Join(l.shards(name), "/") + "/" + name
+}
+
+func (l *HashLayout) Logical(physical string) (logical string, ok bool) {
+	levels, _ := l.levels()
+	parts := strings.Split(physical, "/")
+	if len(parts) <= levels {
+		return "", false
+	}
+
+	name := parts[len(parts)-1]
+	for i, shard := range l.shards(name) {
+		if parts[len(parts)-1-levels+i] != shard {
+			return "", false
+		}
+	}
+	return strings.Join(append(parts[:len(parts)-1-levels:len(parts)-1-levels], name), "/"), true
+}
+
+// physicalPath returns the physical storage path of the logical one.
+func (this *FileSystem) physicalPath(logical string) string {
+	if this.Layout == nil {
+		return logical
+	}
+	return "/" + this.Layout.Physical(strings.Trim(logical, "/"))
+}
+
+// logicalPath returns the logical storage path of the physical one.
+func (this *FileSystem) logicalPath(physical string) (string, bool) {
+	if this.Layout == nil {
+		return physical, true
+	}
+	logical, ok := this.Layout.Logical(strings.Trim(physical, "/"))
+	return "/" + logical, ok
+}
+
+// inLayout reports whether the full path is a physical path of the Layout.
+func (this *FileSystem) inLayout(fullpath string) bool {
+	if this.Layout == nil {
+		return false
+	}
+	_, ok := this.logicalPath(filepath.ToSlash(strings.TrimPrefix(fullpath, this.Base)))
+	return ok
+}
+
+// lookup resolves path like ResolvePath and stats it. With a Layout, a path
+// that isn't stored there is looked up at its flat path, which finds the
+// directories and the files that weren't migrated yet.
+func (this *FileSystem) lookup(path string) (fullpath string, info os.FileInfo, err error) {
+	if fullpath, err = this.ResolvePath(path); err != nil {
+		return
+	}
+	if info, err = os.Stat(fullpath); err == nil || this.Layout == nil || !os.IsNotExist(err) {
+		return
+	}
+
+	flat, ferr := this.resolvePath(path, true)
+	if ferr != nil {
+		return "", nil, ferr
+	}
+	if this.isSidecar(flat) {
+		return "", nil, &ReservedPathError{path}
+	}
+	// the physical paths of the Layout aren't storage paths
+	if flatInfo, ferr := os.Stat(flat); ferr == nil && (flatInfo.IsDir() || !this.inLayout(flat)) {
+		return flat, flatInfo, nil
+	}
+	return
+}
+
+// removeFlat removes the not yet migrated copy of path once the file is
+// stored at fullpath by the Layout.
+func (this *FileSystem) removeFlat(path, fullpath string) error {
+	if this.Layout == nil {
+		return nil
+	}
+	flat, err := this.resolvePath(path, true)
+	if err != nil || flat == fullpath || this.inLayout(flat) || this.isSidecar(flat) {
+		return nil
+	}
+	return this.removeStale(flat)
+}
+
+// removeStale removes the outdated copy fullpath of a file, and its sidecar.
+func (this *FileSystem) removeStale(fullpath string) error {
+	info, err := os.Lstat(fullpath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	if err = this.removeFile(fullpath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errwrap.Wrap(err, "Remove %q", fullpath)
+	}
+	return this.setSidecarMetadata(fullpath, nil)
+}
+
+// Migrate moves the files stored with the from layout, nil for the flat one,
+// to the current layout of the storage. Files that don't belong to the from
+// layout or that already are in the current one are left in place, so an
+// interrupted migration can be run again. Until then the flat files are still
+// found by Stat, Get, List and ServeHTTP.
+//
+// Migrate can run while the storage is in use: uploads in progress are
+// skipped and a file stored by a Put meanwhile is never replaced, the
+// outdated copy is removed instead.
+func (this *FileSystem) Migrate(from Layout) error {
+	// the files are moved during the walk, the files moved into the
+	// directories not walked yet are found in the current layout
+	return filepath.WalkDir(this.Base, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && pth != this.Base {
+				// a directory emptied by the moves
+				return nil
+			}
+			return errwrap.Wrap(err, "Migrate %q", pth)
+		}
+		if d.IsDir() || isTemp(d.Name()) || this.isSidecar(pth) {
+			return nil
+		}
+
+		physical := filepath.ToSlash(strings.TrimPrefix(pth, this.Base))
+		if _, ok := this.logicalPath(physical); ok && this.Layout != nil {
+			// already migrated
+			return nil
+		}
+
+		logical := strings.Trim(physical, "/")
+		if from != nil {
+			var ok bool
+			if logical, ok = from.Logical(logical); !ok {
+				return nil
+			}
+		}
+
+		if to := this.physicalPath(logical); strings.Trim(to, "/") != strings.Trim(physical, "/") {
+			return this.migrateFile(pth, filepath.Join(this.Base, filepath.FromSlash(to)))
+		}
+		return nil
+	})
+}
+
+// migrateFile moves the file from to to, with its sidecar.
+func (this *FileSystem) migrateFile(from, to string) error {
+	if err := this.mkdirAll(filepath.Dir(to)); err != nil {
+		return err
+	}
+
+	exists, err := linkNoReplace(from, to)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// deleted meanwhile
+			return nil
+		}
+		return errwrap.Wrap(err, "Move %q to %q", from, to)
+	}
+	if exists {
+		// a Put stored a newer file meanwhile
+		return this.removeStale(from)
+	}
+
+	// a sidecar at to belongs to a Put in progress, it's kept
+	if _, err = linkNoReplace(sidecarPath(from), sidecarPath(to)); err != nil && !os.IsNotExist(err) {
+		return errwrap.Wrap(err, "Move metadata of %q", from)
+	}
+	for _, pth := range []string{sidecarPath(from), from} {
+		if err = os.Remove(pth); err != nil && !os.IsNotExist(err) {
+			return errwrap.Wrap(err, "Remove %q", pth)
+		}
+	}
+	removeEmptyDirs(filepath.Dir(from), this.Base)
+	return nil
+}
+
+// linkNoReplace makes a hard link to from at to, or renames from to to when
+// hard links aren't supported. It does nothing and reports true if to already
+// exists.
+func linkNoReplace(from, to string) (exists bool, err error) {
+	if err = os.Link(from, to); err == nil || os.IsNotExist(err) {
+		return false, err
+	}
+	if os.IsExist(err) {
+		return true, nil
+	}
+	if _, err = os.Lstat(to); err == nil {
+		return true, nil
+	}
+	return false, os.Rename(from, to)
+}
+
+// removeEmptyDirs removes dir and its parents up to root while they are empty.
+func removeEmptyDirs(dir, root string) {
+	for within(root, dir) && dir != root {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,191 @@
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ecletus/oss"
+	errwrap "github.com/moisespsena-go/error-wrap"
+)
+
+// ListOptions options of ListPage
+type ListOptions struct {
+	// Maximum number of objects. Zero means no limit.
+	Limit int
+	// Return only the objects after this storage path, the next value
+	// returned by the previous page.
+	StartAfter string
+	// OnError is called with the entries that can't be read. Returning nil
+	// skips the entry, returning an error stops the listing with it. By
+	// default the entries are skipped if ListSkipErrors is set, otherwise the
+	// listing fails.
+	OnError func(path string, err error) error
+}
+
+// List list all objects under current path
+func (this *FileSystem) List(path string) ([]*oss.Object, error) {
+	objects, _, err := this.ListPage(path, ListOptions{})
+	return objects, err
+}
+
+// ListPage list the objects under current path in lexical order of their
+// physical paths. Object paths are slash separated logical storage paths. next is the StartAfter value of the next
+// page, or empty if there are no more objects.
+func (this *FileSystem) ListPage(pth string, opts ListOptions) (objects []*oss.Object, next string, err error) {
+	fullpath, err := this.resolvePath(pth, true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	onError := opts.OnError
+	if onError == nil {
+		onError = func(p string, err error) error {
+			if this.ListSkipErrors {
+				return nil
+			}
+			return errwrap.Wrap(err, "List %q", p)
+		}
+	}
+
+	prefix := "/" + strings.Trim(filepath.ToSlash(strings.TrimPrefix(fullpath, this.Base)), "/")
+	var startAfter []string
+	if opts.StartAfter != "" {
+		startAfter = splitPath(this.storedPath(opts.StartAfter))
+	}
+
+	err = fs.WalkDir(os.DirFS(fullpath), ".", func(p string, d fs.DirEntry, err error) error {
+		storagePath := path.Join(prefix, p)
+
+		if err != nil {
+			if p == "." {
+				if os.IsNotExist(err) {
+					return fs.SkipDir
+				}
+				return errwrap.Wrap(err, "List %q", storagePath)
+			}
+			if err = onError(storagePath, err); err == nil && d != nil && d.IsDir() {
+				err = fs.SkipDir
+			}
+			return err
+		}
+
+		if p == "." {
+			return nil
+		}
+
+		if startAfter != nil {
+			if cmp := comparePath(splitPath(storagePath), startAfter); cmp < 0 || cmp == 0 && !d.IsDir() {
+				if d.IsDir() && !isPathPrefix(splitPath(storagePath), startAfter) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+
+		entryPath := filepath.Join(fullpath, filepath.FromSlash(p))
+		if d.IsDir() || isTemp(d.Name()) || this.isSidecar(entryPath) {
+			return nil
+		}
+
+		logicalPath, ok := this.logicalPath(storagePath)
+		if !ok {
+			// not migrated to the Layout yet, unless a copy is stored there
+			if _, err := os.Lstat(this.GetFullPath(this.physicalPath(storagePath))); err == nil {
+				return nil
+			}
+			logicalPath = storagePath
+		}
+
+		var info os.FileInfo
+		if d.Type()&os.ModeSymlink != 0 {
+			if this.Sandbox {
+				if _, err = this.ResolvePath(entryPath); IsOutsideRootError(err) {
+					return nil
+				} else if err != nil {
+					return onError(storagePath, err)
+				}
+			}
+			if info, err = os.Stat(entryPath); err == nil && info.IsDir() {
+				return nil
+			}
+		} else {
+			info, err = d.Info()
+		}
+		if err != nil {
+			return onError(storagePath, err)
+		}
+
+		metadata, err := this.readMetadata(entryPath)
+		if err != nil {
+			if err = onError(storagePath, err); err != nil {
+				return err
+			}
+		}
+
+		if opts.Limit > 0 && len(objects) == opts.Limit {
+			next = objects[len(objects)-1].Path
+			return fs.SkipAll
+		}
+
+		modTime := info.ModTime()
+		objects = append(objects, &oss.Object{
+			Path:             logicalPath,
+			Name:             d.Name(),
+			LastModified:     &modTime,
+			Metadata:         metadata,
+			StorageInterface: this,
+		})
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return
+}
+
+// storedPath returns the physical path where List found the logical path: its
+// Layout path, or its flat path when it wasn't migrated yet.
+func (this *FileSystem) storedPath(logical string) string {
+	physical := this.physicalPath(logical)
+	if this.Layout == nil {
+		return physical
+	}
+	if _, err := os.Lstat(this.GetFullPath(physical)); os.IsNotExist(err) {
+		if flat, _, err := this.lookup(logical); err == nil && !this.inLayout(flat) {
+			return logical
+		}
+	}
+	return physical
+}
+
+func splitPath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '/' })
+}
+
+// comparePath compares slash separated paths element by element, which is the
+// order fs.WalkDir visits them.
+func comparePath(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+func isPathPrefix(prefix, p []string) bool {
+	return len(prefix) <= len(p) && comparePath(prefix, p[:len(prefix)]) == 0
+}
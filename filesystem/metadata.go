@@ -0,0 +1,197 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ecletus/oss"
+	errwrap "github.com/moisespsena-go/error-wrap"
+)
+
+// Metadata storage modes
+const (
+	// MetadataAuto stores metadata in xattrs, or in sidecar files when the
+	// file system doesn't support xattrs.
+	MetadataAuto    = ""
+	MetadataXattr   = "xattr"
+	MetadataSidecar = "sidecar"
+	MetadataNone    = "none"
+)
+
+const (
+	metadataXattr         = "user.oss.metadata"
+	metadataSidecarSuffix = ".meta.json"
+)
+
+var _ oss.MetadataStorageInterface = (*FileSystem)(nil)
+
+type fileInfo struct {
+	os.FileInfo
+	metadata *oss.Metadata
+}
+
+func (fi *fileInfo) Metadata() *oss.Metadata {
+	return fi.metadata
+}
+
+// sidecarPath returns the path of the file that keeps the metadata of
+// fullpath when xattrs aren't available.
+func sidecarPath(fullpath string) string {
+	dir, name := filepath.Split(fullpath)
+	return filepath.Join(dir, "."+name+metadataSidecarSuffix)
+}
+
+// sidecarOf returns the name of the file whose metadata the sidecar file name
+// keeps, or false if name isn't a sidecar file name.
+func sidecarOf(name string) (string, bool) {
+	if len(name) <= 1+len(metadataSidecarSuffix) || name[0] != '.' || !strings.HasSuffix(name, metadataSidecarSuffix) {
+		return "", false
+	}
+	return name[1 : len(name)-len(metadataSidecarSuffix)], true
+}
+
+// sidecars reports whether metadata may be stored in sidecar files.
+func (this *FileSystem) sidecars() bool {
+	return this.Metadata != MetadataNone && this.Metadata != MetadataXattr
+}
+
+// isSidecar reports whether fullpath is the sidecar file of a file that
+// exists or is being uploaded. Other files named like sidecars are regular
+// files.
+func (this *FileSystem) isSidecar(fullpath string) bool {
+	if !this.sidecars() {
+		return false
+	}
+	dir, name := filepath.Split(fullpath)
+	of, ok := sidecarOf(name)
+	if !ok {
+		return false
+	}
+	pth := filepath.Join(dir, of)
+	if info, err := os.Stat(pth); err == nil {
+		return !info.IsDir()
+	}
+	// the sidecar is written before the upload is renamed into place
+	return this.isUploading(pth)
+}
+
+// setXattrMetadata stores metadata in the xattrs of pth. It reports whether
+// the metadata must be stored in a sidecar file instead.
+func (this *FileSystem) setXattrMetadata(pth string, metadata *oss.Metadata) (sidecar bool, err error) {
+	switch this.Metadata {
+	case MetadataNone:
+		return false, nil
+	case MetadataSidecar:
+		return !metadata.IsZero(), nil
+	}
+
+	if metadata.IsZero() {
+		return false, nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return false, err
+	}
+
+	if err = setxattr(pth, metadataXattr, data); err != nil {
+		if this.Metadata == MetadataAuto {
+			return true, nil
+		}
+		return false, errwrap.Wrap(err, "Set metadata xattr of %q", pth)
+	}
+	return false, nil
+}
+
+// setSidecarMetadata writes the sidecar file of fullpath, or removes it when
+// metadata is nil.
+func (this *FileSystem) setSidecarMetadata(fullpath string, metadata *oss.Metadata) error {
+	if this.Metadata == MetadataNone || this.Metadata == MetadataXattr {
+		return nil
+	}
+
+	pth := sidecarPath(fullpath)
+	if metadata == nil {
+		if err := os.Remove(pth); err != nil && !os.IsNotExist(err) {
+			return errwrap.Wrap(err, "Remove metadata file %q", pth)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	mode, err := this.fileMode(fullpath)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(pth), tempPrefix+"*")
+	if err != nil {
+		return errwrap.Wrap(err, "Create metadata file %q", pth)
+	}
+
+	if err = writeSidecar(tmp, data, mode); err == nil {
+		if err = this.chown(tmp.Name()); err == nil {
+			err = os.Rename(tmp.Name(), pth)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errwrap.Wrap(err, "Write metadata file %q", pth)
+	}
+	return nil
+}
+
+func writeSidecar(f *os.File, data []byte, mode os.FileMode) (err error) {
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	if err = f.Chmod(mode); err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	return
+}
+
+// readMetadata returns the metadata of fullpath, or nil if it has none.
+func (this *FileSystem) readMetadata(fullpath string) (metadata *oss.Metadata, err error) {
+	if this.Metadata == MetadataNone {
+		return nil, nil
+	}
+
+	var data []byte
+	if this.Metadata != MetadataSidecar {
+		if data, err = getxattr(fullpath, metadataXattr); err != nil {
+			if this.Metadata == MetadataXattr {
+				if isNoXattr(err) || !xattrSupported {
+					return nil, nil
+				}
+				return nil, errwrap.Wrap(err, "Get metadata xattr of %q", fullpath)
+			}
+			data = nil
+		}
+	}
+
+	if data == nil {
+		if data, err = ioutil.ReadFile(sidecarPath(fullpath)); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, errwrap.Wrap(err, "Read metadata file of %q", fullpath)
+		}
+	}
+
+	metadata = &oss.Metadata{}
+	if err = json.Unmarshal(data, metadata); err != nil {
+		return nil, errwrap.Wrap(err, "Decode metadata of %q", fullpath)
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	errwrap "github.com/moisespsena-go/error-wrap"
+	path_helpers "github.com/moisespsena-go/path-helpers"
+)
+
+// fileMode returns the mode of the new file fullpath: Config.FileMode, or the
+// mode resolved from its parent directory when unset, without the Umask bits.
+func (this *FileSystem) fileMode(fullpath string) (mode os.FileMode, err error) {
+	if this.FileMode != nil {
+		mode = *this.FileMode
+	} else {
+		if mode, err = path_helpers.ResolveFileMode(fullpath); err != nil {
+			return 0, errwrap.Wrap(err, "Resolve mode of %q", fullpath)
+		}
+	}
+	return mode.Perm() &^ this.Umask, nil
+}
+
+// dirMode returns the mode of the new directory dir: Config.DirMode, or the
+// mode resolved from its parent directory when unset, without the Umask bits.
+func (this *FileSystem) dirMode(dir string) (mode os.FileMode, err error) {
+	if this.DirMode != nil {
+		mode = *this.DirMode
+	} else {
+		if mode, err = path_helpers.ResolveMode(dir); err != nil {
+			return 0, errwrap.Wrap(err, "Resolve mode of %q", dir)
+		}
+	}
+	return mode.Perm() &^ this.Umask, nil
+}
+
+// chown sets the configured owner of pth, if any.
+func (this *FileSystem) chown(pth string) error {
+	if this.Uid == nil && this.Gid == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if this.Uid != nil {
+		uid = *this.Uid
+	}
+	if this.Gid != nil {
+		gid = *this.Gid
+	}
+	if err := os.Lchown(pth, uid, gid); err != nil {
+		return errwrap.Wrap(err, "Chown %q", pth)
+	}
+	return nil
+}
+
+// mkdirAll creates dir and its missing parents with the directory mode and
+// owner. The mode is set explicitly, so the process umask doesn't apply.
+func (this *FileSystem) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return errwrap.Wrap(fmt.Errorf("not a directory"), "Create base directory %q", dir)
+		}
+		return nil
+	}
+
+	mode, err := this.dirMode(dir)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for p := dir; ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil || filepath.Dir(p) == p {
+			break
+		}
+		missing = append(missing, p)
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err = os.Mkdir(missing[i], mode); err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return errwrap.Wrap(err, "Create base directory %q", missing[i])
+		}
+		if err = os.Chmod(missing[i], mode); err != nil {
+			return errwrap.Wrap(err, "Chmod %q", missing[i])
+		}
+		if err = this.chown(missing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modeConfig holds the mode keys of a factory config.
+type modeConfig struct {
+	FileMode *os.FileMode
+	DirMode  *os.FileMode
+	Umask    os.FileMode
+}
+
+// parseModes parses the mode keys of a factory config, given as octal strings
+// (e.g. "0640") or numbers. It returns a copy of config without those keys,
+// config itself isn't changed.
+func parseModes(config map[string]interface{}) (m modeConfig, rest map[string]interface{}, err error) {
+	rest = make(map[string]interface{}, len(config))
+	for key, value := range config {
+		rest[key] = value
+	}
+
+	for key, dst := range map[string]**os.FileMode{"FileMode": &m.FileMode, "DirMode": &m.DirMode, "Umask": nil} {
+		value, ok := config[key]
+		if !ok {
+			continue
+		}
+		delete(rest, key)
+		if value == nil {
+			continue
+		}
+		mode, err := parseMode(value)
+		if err != nil {
+			return m, nil, errwrap.Wrap(err, "Parse %s %v", key, value)
+		}
+		if dst != nil {
+			*dst = &mode
+		} else {
+			m.Umask = mode
+		}
+	}
+	return
+}
+
+func parseMode(value interface{}) (os.FileMode, error) {
+	var mode uint64
+	switch v := value.(type) {
+	case string:
+		var err error
+		if mode, err = strconv.ParseUint(v, 8, 32); err != nil {
+			return 0, err
+		}
+	case int:
+		mode = uint64(v)
+	case int64:
+		mode = uint64(v)
+	case uint32:
+		mode = uint64(v)
+	case uint64:
+		mode = v
+	case float64:
+		mode = uint64(v)
+	case os.FileMode:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("unsupported type %T", value)
+	}
+	if mode > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("mode %o has bits other than the permissions", mode)
+	}
+	return os.FileMode(mode), nil
+}
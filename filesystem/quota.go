@@ -0,0 +1,214 @@
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	errwrap "github.com/moisespsena-go/error-wrap"
+)
+
+// QuotaExceededError is returned by Put when the upload would exceed the
+// QuotaBytes or QuotaFiles limits.
+type QuotaExceededError struct {
+	// "bytes" or "files"
+	Quota string
+	Limit int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("filesystem: %s quota of %d exceeded", e.Quota, e.Limit)
+}
+
+func IsQuotaExceededError(err error) bool {
+	_, ok := err.(*QuotaExceededError)
+	return ok
+}
+
+// usage of the storage, loaded on first write and then updated by Put and
+// Delete.
+type usage struct {
+	mu     sync.Mutex
+	loaded bool
+	bytes  int64
+	files  int64
+	// bytes and files of the uploads in progress
+	pendingBytes int64
+	pendingFiles int64
+}
+
+func (this *FileSystem) hasQuota() bool {
+	return this.QuotaBytes > 0 || this.QuotaFiles > 0
+}
+
+// Usage returns the bytes and the number of files stored.
+func (this *FileSystem) Usage() (bytes, files int64, err error) {
+	this.usage.mu.Lock()
+	defer this.usage.mu.Unlock()
+
+	if err = this.loadUsage(); err != nil {
+		return
+	}
+	return this.usage.bytes, this.usage.files, nil
+}
+
+// RecalculateUsage walks the storage again, to account the changes made by
+// other processes.
+func (this *FileSystem) RecalculateUsage() error {
+	this.usage.mu.Lock()
+	defer this.usage.mu.Unlock()
+
+	this.usage.loaded = false
+	return this.loadUsage()
+}
+
+// loadUsage walks the storage if the usage wasn't loaded yet. The usage lock
+// must be held.
+func (this *FileSystem) loadUsage() error {
+	if this.usage.loaded {
+		return nil
+	}
+
+	var bytes, files int64
+	err := filepath.WalkDir(this.Base, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && pth == this.Base {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || isTemp(d.Name()) || this.isSidecar(pth) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		bytes += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		return errwrap.Wrap(err, "Calculate usage of %q", this.Base)
+	}
+
+	this.usage.bytes, this.usage.files, this.usage.loaded = bytes, files, true
+	return nil
+}
+
+// quotaReader reserves the quota of the bytes read from the upload reader.
+// The size of the replaced file is only used to check the quota, the usage
+// is accounted by replace.
+type quotaReader struct {
+	fs      *FileSystem
+	r       io.Reader
+	oldSize int64
+	newFile bool
+	n       int64
+	err     error
+}
+
+// newQuotaReader checks the files quota and starts the upload of r, which
+// replaces a file of oldSize bytes, or a new file if newFile is true.
+func (this *FileSystem) newQuotaReader(r io.Reader, oldSize int64, newFile bool) (*quotaReader, error) {
+	this.usage.mu.Lock()
+	defer this.usage.mu.Unlock()
+
+	if err := this.loadUsage(); err != nil {
+		return nil, err
+	}
+
+	if newFile {
+		if this.QuotaFiles > 0 && this.usage.files+this.usage.pendingFiles >= this.QuotaFiles {
+			return nil, &QuotaExceededError{"files", this.QuotaFiles}
+		}
+		this.usage.pendingFiles++
+	}
+	return &quotaReader{fs: this, r: r, oldSize: oldSize, newFile: newFile}, nil
+}
+
+func (q *quotaReader) Read(p []byte) (n int, err error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+
+	n, err = q.r.Read(p)
+	if n > 0 {
+		u := &q.fs.usage
+		u.mu.Lock()
+		if limit := q.fs.QuotaBytes; limit > 0 && u.bytes+u.pendingBytes+int64(n)-q.oldSize > limit {
+			q.err = &QuotaExceededError{"bytes", limit}
+		} else {
+			u.pendingBytes += int64(n)
+			q.n += int64(n)
+		}
+		u.mu.Unlock()
+
+		if q.err != nil {
+			return 0, q.err
+		}
+	}
+	return
+}
+
+// done releases the reservations.
+func (q *quotaReader) done() {
+	u := &q.fs.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.pendingBytes -= q.n
+	if q.newFile {
+		u.pendingFiles--
+	}
+}
+
+// replace renames the uploaded file tmp of size bytes to fullpath and
+// accounts it. The replaced file is looked up under the usage lock, so
+// concurrent writes of the same path don't skew the usage.
+func (this *FileSystem) replace(tmp, fullpath string, size int64) error {
+	this.usage.mu.Lock()
+	defer this.usage.mu.Unlock()
+
+	var oldSize int64
+	newFile := true
+	if info, err := os.Lstat(fullpath); err == nil {
+		oldSize, newFile = info.Size(), false
+	}
+	if err := os.Rename(tmp, fullpath); err != nil {
+		return err
+	}
+	if this.usage.loaded {
+		this.usage.bytes += size - oldSize
+		if newFile {
+			this.usage.files++
+		}
+	}
+	return nil
+}
+
+// removeFile removes the file fullpath and accounts it, under the usage lock
+// like replace.
+func (this *FileSystem) removeFile(fullpath string) error {
+	this.usage.mu.Lock()
+	defer this.usage.mu.Unlock()
+
+	info, err := os.Lstat(fullpath)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(fullpath); err != nil {
+		return err
+	}
+	if this.usage.loaded {
+		this.usage.bytes -= info.Size()
+		this.usage.files--
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OutsideRootError is returned in sandbox mode when a path, or the target of
+// a symlink in it, resolves outside of the storage root.
+type OutsideRootError struct {
+	Path string
+}
+
+func (e *OutsideRootError) Error() string {
+	return fmt.Sprintf("filesystem: path %q resolves outside of the storage root", e.Path)
+}
+
+func IsOutsideRootError(err error) bool {
+	_, ok := err.(*OutsideRootError)
+	return ok
+}
+
+// ResolvePath get full path from absolute/relative path, mapped by the Layout
+// when set. In sandbox mode it rejects paths with ".." elements and paths
+// whose existing part resolves, through symlinks, outside of Base. Reserved
+// paths are always rejected.
+func (this *FileSystem) ResolvePath(path string) (string, error) {
+	if isTemp(filepath.Base(path)) {
+		return "", &ReservedPathError{path}
+	}
+	fullpath, err := this.resolvePath(path, false)
+	if err == nil && this.isSidecar(fullpath) {
+		return "", &ReservedPathError{path}
+	}
+	return fullpath, err
+}
+
+// resolvePath is ResolvePath. Directory paths aren't mapped by the Layout.
+func (this *FileSystem) resolvePath(path string, dir bool) (string, error) {
+	if !dir && !within(this.Base, path) {
+		path = this.physicalPath(filepath.ToSlash(path))
+	}
+
+	if !this.Sandbox {
+		return this.GetFullPath(path), nil
+	}
+
+	if within(this.Base, path) {
+		path = strings.TrimPrefix(path, this.Base)
+	}
+
+	for _, part := range strings.FieldsFunc(path, isSeparator) {
+		if part == ".." {
+			return "", &OutsideRootError{path}
+		}
+	}
+
+	fullpath := filepath.Join(this.Base, filepath.FromSlash(path))
+	if err := this.checkSymlinks(path, fullpath); err != nil {
+		return "", err
+	}
+	return fullpath, nil
+}
+
+// checkSymlinks resolves the longest existing prefix of fullpath and makes
+// sure it stays under Base.
+func (this *FileSystem) checkSymlinks(path, fullpath string) error {
+	root, err := filepath.EvalSymlinks(this.Base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for p := fullpath; ; {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			if !within(root, real) {
+				return &OutsideRootError{path}
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// dangling symlinks can't be checked
+		if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return &OutsideRootError{path}
+		}
+
+		parent := filepath.Dir(p)
+		if parent == p || !within(this.Base, parent) {
+			return nil
+		}
+		p = parent
+	}
+}
+
+// within reports whether path is root or is under root.
+func within(root, path string) bool {
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == filepath.Separator
+}
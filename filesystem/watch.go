@@ -0,0 +1,305 @@
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ecletus/oss"
+	errwrap "github.com/moisespsena-go/error-wrap"
+)
+
+// EventType type of a watch event
+type EventType int
+
+const (
+	Created EventType = iota + 1
+	Modified
+	Deleted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Created:
+		return "created"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Event change of a file under the watched path
+type Event struct {
+	Type EventType
+	// Logical storage path
+	Path string
+	// Object of the file. Deleted events only have Path and Name.
+	Object *oss.Object
+}
+
+// WatchOptions options of Watch
+type WatchOptions struct {
+	// Events of the same path within this interval are merged into one.
+	// Default is 100ms.
+	Debounce time.Duration
+}
+
+// Watcher emits the changes of the files under a watched path.
+type Watcher struct {
+	Events <-chan Event
+	// Errors of the watch. They are dropped while the previous one wasn't
+	// received.
+	Errors <-chan error
+
+	fs        *FileSystem
+	watcher   *fsnotify.Watcher
+	debounce  time.Duration
+	events    chan Event
+	errors    chan error
+	pending   map[string]*pendingEvent
+	dirs      map[string]bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type pendingEvent struct {
+	typ      EventType
+	deadline time.Time
+}
+
+// Watch emits the files created, modified or deleted under path, including
+// the ones of subdirectories created after the watch starts.
+func (this *FileSystem) Watch(path string, opts ...WatchOptions) (*Watcher, error) {
+	var opt WatchOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Debounce <= 0 {
+		opt.Debounce = 100 * time.Millisecond
+	}
+
+	fullpath, err := this.resolvePath(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errwrap.Wrap(err, "Create watcher")
+	}
+
+	w := &Watcher{
+		fs:       this,
+		watcher:  fw,
+		debounce: opt.Debounce,
+		events:   make(chan Event, 64),
+		errors:   make(chan error, 1),
+		pending:  map[string]*pendingEvent{},
+		dirs:     map[string]bool{},
+		done:     make(chan struct{}),
+	}
+	w.Events, w.Errors = w.events, w.errors
+
+	if err = w.addDir(fullpath, false); err != nil {
+		fw.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Close stops the watcher and closes the Events channel.
+func (w *Watcher) Close() (err error) {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.watcher.Close()
+	})
+	return
+}
+
+// addDir watches dir and its subdirectories. If created is true, the files
+// found in them are emitted as created, because they may have been written
+// before the watch was added.
+func (w *Watcher) addDir(dir string, created bool) error {
+	return filepath.WalkDir(dir, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && pth != dir {
+				return nil
+			}
+			return errwrap.Wrap(err, "Watch %q", pth)
+		}
+		if d.IsDir() {
+			if err = w.watcher.Add(pth); err != nil {
+				return errwrap.Wrap(err, "Watch %q", pth)
+			}
+			w.dirs[pth] = true
+		} else if created {
+			w.push(pth, Created)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case e, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(e)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.error(err)
+		case <-timer.C:
+			if !w.flush() {
+				return
+			}
+		}
+
+		if next := w.nextDeadline(); !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+func (w *Watcher) handle(e fsnotify.Event) {
+	name := filepath.Base(e.Name)
+	if isTemp(name) {
+		return
+	}
+	if _, ok := sidecarOf(name); ok && w.fs.sidecars() {
+		// the file of a removed sidecar is usually gone already
+		if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 || w.fs.isSidecar(e.Name) {
+			return
+		}
+	}
+
+	switch {
+	case e.Op&fsnotify.Create != 0:
+		if info, err := os.Stat(e.Name); err == nil && info.IsDir() {
+			if err = w.addDir(e.Name, true); err != nil {
+				w.error(err)
+			}
+			return
+		}
+		w.push(e.Name, Created)
+	case e.Op&fsnotify.Write != 0:
+		w.push(e.Name, Modified)
+	case e.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		if w.dirs[e.Name] {
+			delete(w.dirs, e.Name)
+			return
+		}
+		w.push(e.Name, Deleted)
+	}
+}
+
+// push merges the event with the pending one of the same path.
+func (w *Watcher) push(pth string, typ EventType) {
+	deadline := time.Now().Add(w.debounce)
+	p, ok := w.pending[pth]
+	if !ok {
+		w.pending[pth] = &pendingEvent{typ, deadline}
+		return
+	}
+
+	p.deadline = deadline
+	switch {
+	case p.typ == Created && typ == Deleted:
+		delete(w.pending, pth)
+	case p.typ == Created:
+	case p.typ == Deleted && typ == Created:
+		p.typ = Modified
+	default:
+		p.typ = typ
+	}
+}
+
+func (w *Watcher) nextDeadline() (next time.Time) {
+	for _, p := range w.pending {
+		if next.IsZero() || p.deadline.Before(next) {
+			next = p.deadline
+		}
+	}
+	return
+}
+
+// flush emits the pending events whose deadline passed. It returns false if
+// the watcher was closed.
+func (w *Watcher) flush() bool {
+	now := time.Now()
+	for pth, p := range w.pending {
+		if p.deadline.After(now) {
+			continue
+		}
+		delete(w.pending, pth)
+
+		event, ok := w.event(pth, p.typ)
+		if !ok {
+			continue
+		}
+		select {
+		case w.events <- event:
+		case <-w.done:
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Watcher) event(pth string, typ EventType) (event Event, ok bool) {
+	physical := "/" + strings.Trim(filepath.ToSlash(strings.TrimPrefix(pth, w.fs.Base)), "/")
+	logical, ok := w.fs.logicalPath(physical)
+	if !ok {
+		// a file that wasn't migrated to the Layout yet
+		if typ == Deleted {
+			if _, _, err := w.fs.lookup(physical); err == nil {
+				// moved by Migrate
+				return
+			}
+		}
+		logical = physical
+	}
+
+	event = Event{Type: typ, Path: logical, Object: &oss.Object{
+		Path:             logical,
+		Name:             filepath.Base(pth),
+		StorageInterface: w.fs,
+	}}
+
+	if typ != Deleted {
+		info, err := os.Stat(pth)
+		if err != nil || info.IsDir() {
+			return event, false
+		}
+		modTime := info.ModTime()
+		event.Object.LastModified = &modTime
+		event.Object.Metadata, _ = w.fs.readMetadata(pth)
+	}
+	return event, true
+}
+
+func (w *Watcher) error(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
@@ -0,0 +1,25 @@
+package filesystem
+
+import "syscall"
+
+const xattrSupported = true
+
+func getxattr(pth, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(pth, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Getxattr(pth, name, buf); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+func setxattr(pth, name string, data []byte) error {
+	return syscall.Setxattr(pth, name, data, 0)
+}
+
+func isNoXattr(err error) bool {
+	return err == syscall.ENODATA
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package filesystem
+
+import "errors"
+
+const xattrSupported = false
+
+var errXattrUnsupported = errors.New("xattrs are not supported")
+
+func getxattr(pth, name string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
+
+func setxattr(pth, name string, data []byte) error {
+	return errXattrUnsupported
+}
+
+func isNoXattr(err error) bool {
+	return false
+}
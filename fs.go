@@ -0,0 +1,460 @@
+package oss
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moisespsena-go/assetfs"
+)
+
+// StorageFS is a read only fs.FS of a storage. Storages have no
+// directories: a path is a directory when List returns objects under it, so
+// ReadDir lists all the objects under the directory, recursively. Stat of a
+// directory lists a single object when the storage has ListPage, like gcs
+// and azblob, and directories have no modification time.
+type StorageFS struct {
+	Storage StorageInterface
+}
+
+var (
+	_ fs.ReadDirFS = (*StorageFS)(nil)
+	_ fs.StatFS    = (*StorageFS)(nil)
+)
+
+// NewStorageFS returns the fs.FS of storage.
+func NewStorageFS(storage StorageInterface) *StorageFS {
+	return &StorageFS{storage}
+}
+
+// NewHTTPFileSystem returns the http.FileSystem of storage.
+func NewHTTPFileSystem(storage StorageInterface) http.FileSystem {
+	return http.FS(NewStorageFS(storage))
+}
+
+func storagePath(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+// Open opens the file or directory name. Files are downloaded with Get on
+// the first Read or Seek.
+func (this *StorageFS) Open(name string) (fs.File, error) {
+	info, err := this.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+	}
+	if info.IsDir() {
+		return &storageDir{fsys: this, name: name, info: info}, nil
+	}
+	return &storageFile{storage: this.Storage, name: name, info: info}, nil
+}
+
+// Stat returns the file info of name.
+func (this *StorageFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &dirInfo{name: "."}, nil
+	}
+
+	info, notFound, err := this.Storage.Stat(storagePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if !notFound {
+		if info.IsDir() {
+			return &dirInfo{name: path.Base(name)}, nil
+		}
+		return info, nil
+	}
+
+	if ok, err := this.hasObjects(name); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	} else if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &dirInfo{name: path.Base(name)}, nil
+}
+
+// pageLister is implemented by the storages that list by pages.
+type pageLister interface {
+	ListPage(pth, pageToken string, maxResults int) ([]*Object, string, error)
+}
+
+// hasObjects reports whether objects are stored under the directory name.
+func (this *StorageFS) hasObjects(name string) (bool, error) {
+	var (
+		objects []*Object
+		err     error
+	)
+	if lister, ok := this.Storage.(pageLister); ok {
+		objects, _, err = lister.ListPage(storagePath(name), "", 1)
+	} else {
+		objects, err = this.Storage.List(storagePath(name))
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return len(objects) > 0, err
+}
+
+// ReadDir returns the entries of the directory name sorted by name.
+func (this *StorageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, err := this.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if len(entries) == 0 && name != "." {
+		if info, notFound, _ := this.Storage.Stat(storagePath(name)); !notFound && info != nil && !info.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// readDir derives the direct children of name from the objects listed
+// under it.
+func (this *StorageFS) readDir(name string) ([]fs.DirEntry, error) {
+	objects, err := this.Storage.List(storagePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	children := map[string]*storageEntry{}
+	for _, object := range objects {
+		rel := strings.TrimPrefix(strings.TrimPrefix(object.Path, "/"), prefix)
+		if rel == "" {
+			continue
+		}
+		child := rel
+		dir := false
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			child, dir = rel[:i], true
+		}
+
+		if children[child] == nil {
+			children[child] = &storageEntry{storage: this.Storage, path: prefix + child, dir: dir}
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, e := range children {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func unwrapPathError(err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
+
+type storageEntry struct {
+	storage StorageInterface
+	path    string
+	dir     bool
+}
+
+func (e *storageEntry) Name() string { return path.Base(e.path) }
+func (e *storageEntry) IsDir() bool  { return e.dir }
+
+func (e *storageEntry) Type() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e *storageEntry) Info() (fs.FileInfo, error) {
+	if e.dir {
+		return &dirInfo{name: e.Name()}, nil
+	}
+	info, notFound, err := e.storage.Stat("/" + e.path)
+	if err != nil {
+		return nil, err
+	}
+	if notFound {
+		return nil, &fs.PathError{Op: "stat", Path: e.path, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+type dirInfo struct {
+	name string
+}
+
+func (di *dirInfo) Name() string    { return di.name }
+func (*dirInfo) Size() int64        { return 0 }
+func (*dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (*dirInfo) ModTime() time.Time { return time.Time{} }
+func (*dirInfo) IsDir() bool        { return true }
+func (*dirInfo) Sys() interface{}   { return nil }
+
+type storageFile struct {
+	storage StorageInterface
+	name    string
+	info    fs.FileInfo
+	file    *os.File
+}
+
+func (f *storageFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *storageFile) open() error {
+	if f.file != nil {
+		return nil
+	}
+	file, err := f.storage.Get(storagePath(f.name))
+	if err != nil {
+		return &fs.PathError{Op: "open", Path: f.name, Err: unwrapPathError(err)}
+	}
+	f.file = file
+	return nil
+}
+
+func (f *storageFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Read(p)
+}
+
+func (f *storageFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Seek(offset, whence)
+}
+
+func (f *storageFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.ReadAt(p, off)
+}
+
+// Close closes the downloaded file and removes it if it's a spool.
+func (f *storageFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	if e := RemoveSpool(f.file); err == nil {
+		err = e
+	}
+	return err
+}
+
+type storageDir struct {
+	fsys    *StorageFS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *storageDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (*storageDir) Close() error                 { return nil }
+
+func (d *storageDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *storageDir) ReadDir(n int) (entries []fs.DirEntry, err error) {
+	if !d.read {
+		if d.entries, err = d.fsys.readDir(d.name); err != nil {
+			return nil, err
+		}
+		d.read = true
+	}
+	if n <= 0 || n >= len(d.entries) {
+		entries, d.entries = d.entries, nil
+		if n > 0 && len(entries) == 0 {
+			return nil, io.EOF
+		}
+		return entries, nil
+	}
+	entries, d.entries = d.entries[:n], d.entries[n:]
+	return entries, nil
+}
+
+// FSStorage is a read only storage of an fs.FS, such as embed.FS. Put and
+// Delete return ErrReadOnly.
+type FSStorage struct {
+	FS       fs.FS
+	Endpoint Endpoint
+
+	assets AssetExport
+}
+
+// NewFSStorage returns the storage of fsys.
+func NewFSStorage(fsys fs.FS) *FSStorage {
+	return &FSStorage{FS: fsys, Endpoint: Endpoint{Path: "!"}}
+}
+
+func fsPath(pth string) string {
+	pth = strings.Trim(path.Clean("/"+pth), "/")
+	if pth == "" {
+		return "."
+	}
+	return pth
+}
+
+func (this *FSStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := fsPath(r.URL.Path)
+	f, err := this.FS.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+func (this *FSStorage) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	if info, err = fs.Stat(this.FS, fsPath(pth)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	return info, false, nil
+}
+
+// Get receive file with given path. fs.FS has no *os.File, so the file is
+// read into an unlinked temporary one.
+func (this *FSStorage) Get(pth string) (file *os.File, err error) {
+	src, err := this.FS.Open(fsPath(pth))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	if file, err = ioutil.TempFile("", "oss-fs"); err != nil {
+		return
+	}
+	os.Remove(file.Name())
+
+	if _, err = io.Copy(file, src); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return
+}
+
+func (this *FSStorage) Put(pth string, reader io.Reader) (*Object, error) {
+	return nil, &os.PathError{Op: "put", Path: pth, Err: ErrReadOnly}
+}
+
+func (this *FSStorage) Delete(pth string) error {
+	return &os.PathError{Op: "remove", Path: pth, Err: ErrReadOnly}
+}
+
+// List list all files under current path
+func (this *FSStorage) List(pth string) (objects []*Object, err error) {
+	err = fs.WalkDir(this.FS, fsPath(pth), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == fsPath(pth) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		modTime := info.ModTime()
+		objects = append(objects, &Object{
+			Path:             "/" + p,
+			Name:             d.Name(),
+			LastModified:     &modTime,
+			StorageInterface: this,
+		})
+		return nil
+	})
+	return
+}
+
+// GetEndpoint get endpoint
+func (this *FSStorage) GetEndpoint() *Endpoint {
+	return &this.Endpoint
+}
+
+func (this *FSStorage) GetURL(p ...string) (url string) {
+	url = this.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (this *FSStorage) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = this.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+// AssetFS exports the files under "assets" of FS, see oss.AssetExport.
+func (this *FSStorage) AssetFS() (assetfs.Interface, error) {
+	return this.assets.AssetFS(this)
+}
+
+// Close removes the files of AssetFS.
+func (this *FSStorage) Close() error {
+	return this.assets.Close()
+}
@@ -0,0 +1,214 @@
+package oss_test
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/memory"
+	"github.com/ecletus/oss/tests"
+)
+
+var files = map[string]string{
+	"index.html":          "<html></html>",
+	"css/style.css":       "body{}",
+	"templates/a/b.tmpl":  "{{.}}",
+	"templates/a/c/d.txt": "d",
+}
+
+func TestStorageFS(t *testing.T) {
+	storage := memory.New(&memory.Config{})
+	for name, content := range files {
+		if _, err := storage.Put(name, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := oss.NewStorageFS(storage)
+	if err := fstest.TestFS(fsys, "index.html", "css/style.css", "templates/a/b.tmpl", "templates/a/c/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fs.ReadDir(fsys, "templates/a")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "b.tmpl" || entries[1].Name() != "c" || !entries[1].IsDir() {
+		t.Errorf("bad entries %v %v", entries, err)
+	}
+	if _, err = fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	server := httptest.NewServer(http.FileServer(oss.NewHTTPFileSystem(storage)))
+	defer server.Close()
+	res, err := http.Get(server.URL + "/css/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(data) != "body{}" {
+		t.Errorf("bad response %v %q", res.StatusCode, data)
+	}
+}
+
+func TestFSStorage(t *testing.T) {
+	mapFS := fstest.MapFS{}
+	for name, content := range files {
+		mapFS[name] = &fstest.MapFile{Data: []byte(content), ModTime: time.Now()}
+	}
+	storage := oss.NewFSStorage(mapFS)
+
+	objects, err := storage.List("/templates")
+	if err != nil || len(objects) != 2 || objects[0].Path != "/templates/a/b.tmpl" || objects[1].Path != "/templates/a/c/d.txt" {
+		t.Errorf("bad list %v %v", objects, err)
+	}
+	if objects, err = storage.List("/missing"); err != nil || len(objects) != 0 {
+		t.Errorf("bad list %v %v", objects, err)
+	}
+
+	info, notFound, err := storage.Stat("/css/style.css")
+	if err != nil || notFound || info.Size() != 6 {
+		t.Errorf("bad stat %v %v", notFound, err)
+	}
+	if _, notFound, _ = storage.Stat("missing"); !notFound {
+		t.Errorf("expected not found")
+	}
+
+	file, err := storage.Get("templates/a/c/d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "d" {
+		t.Errorf("bad content %q", data)
+	}
+
+	w := httptest.NewRecorder()
+	storage.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "<html></html>" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("bad response %v %q", w.Code, w.Body.String())
+	}
+
+	if _, err = storage.Put("a", strings.NewReader("a")); !oss.IsErrReadOnly(err) || err.Error() != "put a: read only storage" {
+		t.Errorf("expected read only, got %v", err)
+	}
+	if err = storage.Delete("index.html"); !oss.IsErrReadOnly(err) {
+		t.Errorf("expected read only, got %v", err)
+	}
+}
+
+func TestAssetExport(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	storage := oss.NewFSStorage(fstest.MapFS{"assets/a/b.css": {Data: []byte("b")}})
+	exports := func() []string {
+		dirs, err := filepath.Glob(filepath.Join(tmp, "oss-assets*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dirs
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := storage.AssetFS(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirs := exports()
+	if len(dirs) != 1 {
+		t.Fatalf("expected one export, got %v", dirs)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dirs[0], "a", "b.css")); err != nil || string(data) != "b" {
+		t.Errorf("exported asset: %q %v", data, err)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if dirs := exports(); len(dirs) != 0 {
+		t.Errorf("export not removed: %v", dirs)
+	}
+
+	// the spools of the exported objects are removed
+	var assets oss.AssetExport
+	defer assets.Close()
+	source := tests.SpoolStorage{StorageInterface: memory.New(&memory.Config{})}
+	source.Put("assets/a.css", strings.NewReader("a"))
+	if _, err := assets.AssetFS(source); err != nil {
+		t.Fatal(err)
+	}
+	if spools, _ := filepath.Glob(filepath.Join(tmp, "oss-spool-*")); len(spools) != 0 {
+		t.Errorf("spools not removed: %v", spools)
+	}
+}
+
+func TestStorageFSRemovesSpools(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	storage := tests.SpoolStorage{StorageInterface: memory.New(&memory.Config{})}
+	if _, err := storage.Put("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(oss.NewStorageFS(storage), "a.txt"); err != nil || string(data) != "a" {
+		t.Fatalf("ReadFile: %q %v", data, err)
+	}
+	if spools, _ := filepath.Glob(filepath.Join(tmp, "*")); len(spools) != 0 {
+		t.Errorf("spools not removed: %v", spools)
+	}
+
+	// files of local storages are kept
+	local, err := os.Create(filepath.Join(t.TempDir(), "oss-spool-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.Close()
+	if oss.IsSpool(local) {
+		t.Errorf("%v isn't a spool", local.Name())
+	}
+}
+
+// pagedStorage lists by pages like gcs and azblob.
+type pagedStorage struct {
+	*memory.Storage
+	t *testing.T
+}
+
+func (this pagedStorage) List(pth string) ([]*oss.Object, error) {
+	this.t.Errorf("List(%q) should not be called", pth)
+	return this.Storage.List(pth)
+}
+
+func (this pagedStorage) ListPage(pth, pageToken string, maxResults int) ([]*oss.Object, string, error) {
+	objects, err := this.Storage.List(pth)
+	if maxResults > 0 && len(objects) > maxResults {
+		objects = objects[:maxResults]
+	}
+	return objects, "", err
+}
+
+func TestStorageFSStatDir(t *testing.T) {
+	storage := pagedStorage{memory.New(&memory.Config{}), t}
+	for name, content := range files {
+		if _, err := storage.Put(name, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := oss.NewStorageFS(storage)
+	if info, err := fs.Stat(fsys, "templates/a"); err != nil || !info.IsDir() {
+		t.Errorf("expected directory, got %v %v", info, err)
+	}
+	if _, err := fs.Stat(fsys, "templates/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/secsy/goftp"
+)
+
+var _ io.Closer = Client{}
+
+// ErrClosed is returned when the client is used after Close.
+var ErrClosed = errors.New("ftp: client closed")
+
+// connection holds the lazily dialed goftp connection pool.
+type connection struct {
+	config   *Config
+	owner    *Client
+	mu       sync.Mutex
+	client   *goftp.Client
+	lastUsed time.Time
+	closed   bool
+}
+
+func (conn *connection) goftpConfig() goftp.Config {
+	return goftp.Config{
+		User:               conn.config.User,
+		Password:           conn.config.Password,
+		Timeout:            time.Duration(conn.config.Timeout) * time.Second,
+		ConnectionsPerHost: conn.config.ConnectionsPerHost,
+		ActiveTransfers:    conn.config.ActiveTransfers,
+		ActiveListenAddr:   conn.config.ActiveListenAddr,
+		DisableEPSV:        conn.config.DisableEPSV,
+		IPv6Lookup:         conn.config.IPv6Lookup,
+	}
+}
+
+// get returns the connection pool, dialing it on first use and recycling it
+// when it was idle for longer than Config.IdleTimeout.
+func (conn *connection) get() (*goftp.Client, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+
+	if conn.client != nil && conn.config.IdleTimeout > 0 &&
+		now.Sub(conn.lastUsed) > time.Duration(conn.config.IdleTimeout)*time.Second {
+		conn.set(nil)
+	}
+
+	if conn.client == nil {
+		client, err := goftp.DialConfig(conn.goftpConfig(), conn.config.Hosts...)
+		if err != nil {
+			return nil, err
+		}
+		conn.set(client)
+	}
+
+	conn.lastUsed = now
+	return conn.client, nil
+}
+
+// reset closes the connection pool if it is still the given one, so the next
+// call to get dials again.
+func (conn *connection) reset(client *goftp.Client) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.client == client {
+		conn.set(nil)
+	}
+}
+
+// set closes the current connection pool and replaces it with client. The
+// caller must hold conn.mu.
+func (conn *connection) set(client *goftp.Client) {
+	if conn.client != nil {
+		conn.client.Close()
+	}
+	conn.client = client
+	if conn.owner != nil {
+		conn.owner.Client = client
+	}
+}
+
+// do calls f with the connection pool. If f fails because the server dropped
+// the connection and retry is true, the pool is redialed and f is called once
+// again.
+func (conn *connection) do(retry bool, f func(c *goftp.Client) error) error {
+	client, err := conn.get()
+	if err != nil {
+		return err
+	}
+
+	if err = f(client); err != nil && isConnError(err) {
+		conn.reset(client)
+
+		if retry {
+			if client, err = conn.get(); err != nil {
+				return err
+			}
+			err = f(client)
+		}
+	}
+	return err
+}
+
+func (conn *connection) ping() error {
+	client, err := conn.get()
+	if err != nil {
+		return err
+	}
+
+	raw, err := client.OpenRawConn()
+	if err != nil {
+		if isConnError(err) {
+			conn.reset(client)
+		}
+		return err
+	}
+	defer raw.Close()
+
+	code, msg, err := raw.SendCommand("NOOP")
+	if err == nil && code != 200 {
+		err = fmt.Errorf("ftp: unexpected NOOP response: %d %s", code, msg)
+	}
+	if err != nil {
+		// the server is unhealthy, don't hand its connections out again
+		conn.reset(client)
+	}
+	return err
+}
+
+func (conn *connection) close() (err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.closed {
+		return nil
+	}
+	conn.closed = true
+
+	if conn.client != nil {
+		err = conn.client.Close()
+		conn.client = nil
+		if conn.owner != nil {
+			conn.owner.Client = nil
+		}
+	}
+	return
+}
+
+// isConnError reports whether err means the control connection is unusable:
+// a network failure (goftp reports them without a reply code), or the 421
+// reply the servers send before closing idle connections.
+func isConnError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if ftpErr, ok := err.(goftp.Error); ok {
+		return ftpErr.Code() == 0 || ftpErr.Code() == 421
+	}
+	return false
+}
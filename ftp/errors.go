@@ -0,0 +1,94 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/secsy/goftp"
+)
+
+// DataConnError is returned when a data connection can't be established. It
+// usually means the transfer mode doesn't fit the network between the client
+// and the server.
+type DataConnError struct {
+	// "active", "passive (EPSV)" or "passive (PASV)"
+	Mode string
+	Err  error
+}
+
+func (e *DataConnError) Error() string {
+	return fmt.Sprintf("ftp: %s data connection failed: %v (check the ActiveTransfers and DisableEPSV settings)", e.Mode, e.Err)
+}
+
+func (e *DataConnError) Unwrap() error {
+	return e.Err
+}
+
+func IsDataConnError(err error) bool {
+	var e *DataConnError
+	return errors.As(err, &e)
+}
+
+// dataConnError wraps err into DataConnError when the server refused or
+// couldn't open the data connection.
+func (client Client) dataConnError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	ftpErr, ok := err.(goftp.Error)
+	if !ok {
+		return err
+	}
+
+	switch code := ftpErr.Code(); {
+	case code == 425, code == 426, code == 522:
+	case code == 0 && isDataConnMessage(ftpErr.Error()):
+	default:
+		return err
+	}
+
+	mode := "active"
+	if !client.Config.ActiveTransfers {
+		if client.Config.DisableEPSV {
+			mode = "passive (PASV)"
+		} else {
+			mode = "passive (EPSV)"
+		}
+	}
+	return &DataConnError{mode, err}
+}
+
+// dataConnMessage matches the goftp errors without reply code that come from
+// opening the data connection: they name the data connection or one of the
+// PASV, EPSV, PORT and EPRT commands as a whole word.
+var dataConnMessage = regexp.MustCompile(`\b(PASV|EPSV|PORT|EPRT)\b|(?i)\bdata conn(ection)?\b`)
+
+// isDataConnMessage reports whether a goftp error without reply code comes
+// from opening the data connection.
+func isDataConnMessage(msg string) bool {
+	return dataConnMessage.MatchString(msg)
+}
+
+// RenameError is returned by Put when Config.DeleteBeforeRename deleted the
+// existing file but the upload couldn't be renamed to its name. The upload is
+// kept at Temp.
+type RenameError struct {
+	Temp string
+	Path string
+	Err  error
+}
+
+func (e *RenameError) Error() string {
+	return fmt.Sprintf("ftp: %s was deleted but the upload couldn't be renamed to it, it's kept at %s: %v", e.Path, e.Temp, e.Err)
+}
+
+func (e *RenameError) Unwrap() error {
+	return e.Err
+}
+
+func IsRenameError(err error) bool {
+	var e *RenameError
+	return errors.As(err, &e)
+}
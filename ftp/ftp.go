@@ -1,11 +1,15 @@
 package ftp
 
 import (
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,7 +30,7 @@ func init() {
 
 		if ctx.Var != nil {
 			ctx.Var.FormatPathPtr(&cfg.RootDir).
-				FormatPtr(&cfg.Endpoint.Path, &cfg.Endpoint.Host, &cfg.User, &cfg.Password)
+				FormatPtr(&cfg.Endpoint.Path, &cfg.Endpoint.Host, &cfg.User, &cfg.Password, &cfg.ActiveListenAddr)
 
 			for i := range cfg.Hosts {
 				ctx.Var.FormatPtr(&cfg.Hosts[i])
@@ -46,34 +50,71 @@ type Config struct {
 	ConnectionsPerHost int
 	// value in seconds
 	Timeout int64
+	// value in seconds. The connection pool is recycled before use when it was
+	// idle for longer than this value. Zero disables it.
+	IdleTimeout int64
+	// Upload to a temporary name in the target directory and rename it to the
+	// final name only after the upload succeeds.
+	AtomicUpload bool
+	// Temporary file name is TempPrefix + name + "." + unique id + TempSuffix.
+	// Defaults are "." and ".part".
+	TempPrefix string
+	TempSuffix string
+	// When the server refuses to rename the upload over an existing file,
+	// delete that file and rename again. The replacement isn't atomic: if the
+	// second rename fails the old file is gone and the upload is kept under
+	// its temporary name. Only used with AtomicUpload.
+	DeleteBeforeRename bool
+	// Use active mode (PORT/EPRT) data connections instead of passive mode.
+	ActiveTransfers bool
+	// Local "host:port" listened for active data connections. Default is the
+	// local address of the control connection with a random port.
+	ActiveListenAddr string
+	// Use PASV instead of EPSV for passive data connections.
+	DisableEPSV bool
+	// Resolve hosts to IPv6 addresses too.
+	IPv6Lookup bool
 }
 
 type Client struct {
 	Config Config
+	// Deprecated: Client is nil until the first operation dials the server
+	// and is replaced when the connection pool is recycled. Use Conn.
 	Client *goftp.Client
 	fs     http.FileSystem
+	conn   *connection
 }
 
+// New initialize FTP storage. The connection pool is dialed lazily on first use.
 func New(config Config) (*Client, error) {
-	client, err := goftp.DialConfig(goftp.Config{
-		User:               config.User,
-		Password:           config.Password,
-		Timeout:            time.Duration(config.Timeout) * time.Second,
-		ConnectionsPerHost: config.ConnectionsPerHost,
-	}, config.Hosts...)
+	if len(config.Hosts) == 0 {
+		return nil, errors.New("ftp: no hosts configured")
+	}
 
-	if err != nil {
-		return nil, err
+	if config.ActiveListenAddr != "" {
+		if !config.ActiveTransfers {
+			return nil, errors.New("ftp: ActiveListenAddr requires ActiveTransfers")
+		}
+		if _, _, err := net.SplitHostPort(config.ActiveListenAddr); err != nil {
+			return nil, fmt.Errorf("ftp: bad ActiveListenAddr %q: %v", config.ActiveListenAddr, err)
+		}
 	}
 
 	if config.RootDir != "" {
 		config.RootDir = strings.TrimPrefix(config.RootDir, "/")
 	}
 
+	if config.AtomicUpload {
+		if config.TempPrefix == "" && config.TempSuffix == "" {
+			config.TempPrefix, config.TempSuffix = ".", ".part"
+		}
+	}
+
 	if config.Endpoint.Path != "" {
 		config.Endpoint.Path = strings.TrimSuffix(config.Endpoint.Path, "/")
 	}
-	c := &Client{config, client, nil}
+	c := &Client{Config: config}
+	c.conn = &connection{config: &c.Config, owner: c}
 	return c, nil
 }
 
@@ -87,6 +128,10 @@ func (client Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	defer func() {
+		file.Close()
+		oss.RemoveSpool(file)
+	}()
 	s, err := file.Stat()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -115,8 +160,17 @@ func (client Client) Path(path string) string {
 func (client Client) Get(path string) (file *os.File, err error) {
 	path = client.Path(path)
 
-	if file, err = ioutil.TempFile("/tmp", "s3"); err == nil {
-		err = client.Client.Retrieve(path, file)
+	if file, err = oss.NewSpool(); err == nil {
+		err = client.conn.do(true, func(c *goftp.Client) error {
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := file.Seek(0, 0); err != nil {
+				return err
+			}
+			return c.Retrieve(path, file)
+		})
+		err = client.dataConnError(err)
 		if err == nil {
 			file.Seek(0, 0)
 			return file, nil
@@ -141,7 +195,10 @@ func (client Client) MkdirAll(path string) error {
 		} else {
 			tmp = p
 		}
-		_, err := client.Client.Stat(tmp)
+		err := client.conn.do(true, func(c *goftp.Client) (err error) {
+			_, err = c.Stat(tmp)
+			return
+		})
 
 		if err != nil {
 			if ftpErr, ok := err.(goftp.Error); ok && ftpErr.Code() == 550 {
@@ -161,7 +218,10 @@ func (client Client) MkdirAll(path string) error {
 		} else {
 			dir = p
 		}
-		_, err := client.Client.Mkdir(dir)
+		err := client.conn.do(true, func(c *goftp.Client) (err error) {
+			_, err = c.Mkdir(dir)
+			return
+		})
 
 		if err != nil {
 			return err
@@ -173,7 +233,8 @@ func (client Client) MkdirAll(path string) error {
 
 // Put store a reader into given path
 func (client Client) Put(path string, reader io.Reader) (*oss.Object, error) {
-	if seeker, ok := reader.(io.ReadSeeker); ok {
+	seeker, canRetry := reader.(io.ReadSeeker)
+	if canRetry {
 		seeker.Seek(0, 0)
 	}
 
@@ -184,9 +245,31 @@ func (client Client) Put(path string, reader io.Reader) (*oss.Object, error) {
 		return nil, err
 	}
 
-	err = client.Client.Store(rpath, reader)
+	storePath := rpath
+	if client.Config.AtomicUpload {
+		storePath = client.tempPath(rpath)
+	}
+
+	err = client.conn.do(canRetry, func(c *goftp.Client) error {
+		if canRetry {
+			if _, err := seeker.Seek(0, 0); err != nil {
+				return err
+			}
+		}
+		return c.Store(storePath, reader)
+	})
+	err = client.dataConnError(err)
+
+	if err == nil && storePath != rpath {
+		err = client.rename(storePath, rpath)
+	}
 
 	if err != nil {
+		if storePath != rpath && !IsRenameError(err) {
+			client.conn.do(true, func(c *goftp.Client) error {
+				return c.Delete(storePath)
+			})
+		}
 		return nil, err
 	}
 
@@ -199,9 +282,53 @@ func (client Client) Put(path string, reader io.Reader) (*oss.Object, error) {
 	}, err
 }
 
+// tempPath returns the temporary upload path of rpath, in the same directory.
+func (client Client) tempPath(rpath string) string {
+	dir, name := path.Split(rpath)
+	return dir + client.Config.TempPrefix + name + "." + strconv.FormatInt(time.Now().UnixNano(), 36) + client.Config.TempSuffix
+}
+
+// rename moves the upload from to to. When the server refuses it and
+// Config.DeleteBeforeRename is set, an existing file at to is deleted and the
+// rename retried. If the retry fails too, the upload is kept and *RenameError
+// is returned.
+func (client Client) rename(from, to string) error {
+	err := client.conn.do(true, func(c *goftp.Client) error {
+		return c.Rename(from, to)
+	})
+	if ftpErr, ok := err.(goftp.Error); !ok || ftpErr.Code() != 550 || !client.Config.DeleteBeforeRename {
+		return err
+	}
+
+	var info os.FileInfo
+	if client.conn.do(true, func(c *goftp.Client) (err error) {
+		info, err = c.Stat(to)
+		return
+	}) != nil || info.IsDir() {
+		return err
+	}
+
+	if client.conn.do(true, func(c *goftp.Client) error {
+		return c.Delete(to)
+	}) != nil {
+		return err
+	}
+
+	if err = client.conn.do(true, func(c *goftp.Client) error {
+		return c.Rename(from, to)
+	}); err != nil {
+		return &RenameError{Temp: from, Path: to, Err: err}
+	}
+	return nil
+}
+
 // Delete delete file
 func (client Client) Stat(path string) (info os.FileInfo, notFound bool, err error) {
-	stat, err := client.Client.Stat(client.Path(path))
+	var stat os.FileInfo
+	err = client.conn.do(true, func(c *goftp.Client) (err error) {
+		stat, err = c.Stat(client.Path(path))
+		return
+	})
 	if err != nil {
 		if ftpError, ok := err.(goftp.Error); ok && ftpError.Code() == 550 {
 			return nil, true, nil
@@ -212,13 +339,19 @@ func (client Client) Stat(path string) (info os.FileInfo, notFound bool, err err
 
 // Delete delete file
 func (client Client) Delete(path string) error {
-	return client.Client.Delete(client.Path(path))
+	return client.conn.do(true, func(c *goftp.Client) error {
+		return c.Delete(client.Path(path))
+	})
 }
 
 // List list all objects under current path
 func (client Client) List(path string) ([]*oss.Object, error) {
 	var objects []*oss.Object
-	items, err := client.Client.ReadDir(client.Path(path))
+	var items []os.FileInfo
+	err := client.conn.do(true, func(c *goftp.Client) (err error) {
+		items, err = c.ReadDir(client.Path(path))
+		return
+	})
 
 	if err == nil {
 		for _, content := range items {
@@ -232,7 +365,7 @@ func (client Client) List(path string) ([]*oss.Object, error) {
 		}
 	}
 
-	return objects, err
+	return objects, client.dataConnError(err)
 }
 
 // GetEndpoint get endpoint, FileSystem's endpoint is /
@@ -256,6 +389,22 @@ func (client Client) GetDynamicURL(scheme, host string, p ...string) (url string
 	return
 }
 
+// Conn returns the underlying goftp connection pool, dialing it if needed.
+func (client Client) Conn() (*goftp.Client, error) {
+	return client.conn.get()
+}
+
+// Ping checks the server health by sending a NOOP command over a new control
+// connection.
+func (client Client) Ping() error {
+	return client.conn.ping()
+}
+
+// Close closes the connection pool. The client can't be used after it.
+func (client Client) Close() error {
+	return client.conn.close()
+}
+
 func (this Client) AssetFS() (assetfs.Interface, error) {
 	return nil, oss.ErrAssetFsUnavailable
 }
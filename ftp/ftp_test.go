@@ -1,25 +1,69 @@
 package ftp_test
 
 import (
-	"github.com/goftp/server"
-	"testing"
-	"github.com/ecletus/oss/ftp"
 	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	filedriver "github.com/goftp/file-driver"
+	"github.com/goftp/server"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/ftp"
 )
 
-var client *ftp.Client
+var _ oss.StorageInterface = ftp.Client{}
 
-var port = 7778
+var (
+	client  *ftp.Client
+	addr    string
+	rootDir string
+)
+
+type Auth struct {
+	server.Auth
+}
+
+func (Auth) CheckPasswd(username string, password string) (bool, error) {
+	return username == "user" && password == "pwd", nil
+}
 
-func init() {
+// TestMain starts an in-process FTP server backed by a temporary directory.
+func TestMain(m *testing.M) {
 	var err error
+	if rootDir, err = ioutil.TempDir("", "oss-ftp"); err != nil {
+		panic(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	addr = l.Addr().String()
+
+	srv := server.NewServer(&server.ServerOpts{
+		Factory: &filedriver.FileDriverFactory{
+			RootPath: rootDir,
+			Perm:     server.NewSimplePerm("user", "group"),
+		},
+		Auth:     Auth{},
+		Hostname: "127.0.0.1",
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		Logger:   &server.DiscardLogger{},
+	})
+	go srv.Serve(l)
 
 	client, err = ftp.New(ftp.Config{
-		Hosts:    []string{"localhost:21"},
-		User:     "test_user",
-		Password: "test",
-		Endpoint: "http://localhost/u/test_user/root/dir",
+		Hosts:    []string{addr},
+		User:     "user",
+		Password: "pwd",
+		Endpoint: oss.Endpoint{Scheme: "http", Host: "localhost", Path: "/u/user/root/dir"},
 		RootDir:  "root/dir",
 	})
 
@@ -27,18 +71,12 @@ func init() {
 		panic(err)
 	}
 
-}
+	code := m.Run()
 
-type Auth struct {
-	server.Auth
-}
-
-func (Auth) CheckPasswd(username string, password string) (bool, error) {
-	return username == "user" && password == "pwd", nil
-}
-
-func TestAll(t *testing.T) {
-	TestPath(t)
+	client.Close()
+	srv.Shutdown()
+	os.RemoveAll(rootDir)
+	os.Exit(code)
 }
 
 func TestPath(t *testing.T) {
@@ -52,20 +90,37 @@ func TestPath(t *testing.T) {
 	}
 }
 
+// fixture writes data to the server directory of client's path p.
+func fixture(t *testing.T, p, data string) {
+	t.Helper()
+	pth := filepath.Join(rootDir, "root", "dir", filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pth, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPut(t *testing.T) {
 	buf := bytes.NewBufferString("d1")
-	o, err := client.Put("b/a", buf)
+	o, err := client.Put("put/a", buf)
 	if err != nil {
-		t.Error("#1: ", err)
-		t.Fail()
+		t.Fatal("#1: ", err)
 	}
-	if o.Path != "b/a" {
+	if o.Path != "put/a" {
 		t.Error("#2: path failed")
 	}
+	data, err := ioutil.ReadFile(filepath.Join(rootDir, "root", "dir", "put", "a"))
+	if err != nil || string(data) != "d1" {
+		t.Errorf("#3: %q %v", data, err)
+	}
 }
 
 func TestList(t *testing.T) {
-	items, err := client.List("b")
+	fixture(t, "list/a", "d1")
+
+	items, err := client.List("list")
 	if err != nil {
 		t.Error("#1: ", err)
 		t.Fail()
@@ -78,11 +133,13 @@ func TestList(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
-	file, err := client.Get("b/a")
+	fixture(t, "get/a", "d1")
+
+	file, err := client.Get("get/a")
 	if err != nil {
-		t.Error("#1: ", err)
-		t.Fail()
+		t.Fatal("#1: ", err)
 	}
+	defer file.Close()
 
 	data, err := ioutil.ReadAll(file)
 	if err != nil {
@@ -95,17 +152,191 @@ func TestGet(t *testing.T) {
 		t.Fail()
 	}
 }
-/*
+
 func TestDelete(t *testing.T) {
-	err := client.Delete("b/a")
+	fixture(t, "delete/a", "d1")
+
+	err := client.Delete("delete/a")
 	if err != nil {
 		t.Error("#1: ", err)
 		t.Fail()
 	}
-}*/
-
+	if _, notFound, err := client.Stat("delete/a"); err != nil || !notFound {
+		t.Error("#2: file not deleted")
+	}
+}
 
 func TestEndpoint(t *testing.T) {
-	ep := client.GetEndpoint()
-	println( ep + "/b/a")
-}
\ No newline at end of file
+	if url := client.GetURL("b/a"); url != "http://localhost/u/user/root/dir/b/a" {
+		t.Errorf("bad url %q", url)
+	}
+}
+
+func TestAtomicPut(t *testing.T) {
+	atomic, err := ftp.New(ftp.Config{
+		Hosts:        []string{addr},
+		User:         "user",
+		Password:     "pwd",
+		RootDir:      "atomic",
+		AtomicUpload: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer atomic.Close()
+
+	if _, err = atomic.Put("c/a", strings.NewReader("d2")); err != nil {
+		t.Fatal("#1: ", err)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(rootDir, "atomic", "c"))
+	if err != nil {
+		t.Fatal("#2: ", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a" {
+		t.Errorf("#3: temporary file left behind: %v", entries)
+	}
+}
+
+func TestAtomicPutReplace(t *testing.T) {
+	atomic, err := ftp.New(ftp.Config{
+		Hosts:        []string{addr},
+		User:         "user",
+		Password:     "pwd",
+		RootDir:      "atomic",
+		AtomicUpload: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer atomic.Close()
+
+	for _, data := range []string{"v1", "v2"} {
+		if _, err = atomic.Put("replace/a", strings.NewReader(data)); err != nil {
+			t.Fatal("#1: ", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(rootDir, "atomic", "replace", "a"))
+	if err != nil || string(data) != "v2" {
+		t.Errorf("#2: %q %v", data, err)
+	}
+}
+
+func TestAtomicPutRenameRefused(t *testing.T) {
+	// a non-empty directory at the destination makes RNTO fail with 550
+	dir := filepath.Join(rootDir, "atomic", "refused")
+	if err := os.MkdirAll(filepath.Join(dir, "a", "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, deleteBeforeRename := range []bool{false, true} {
+		atomic, err := ftp.New(ftp.Config{
+			Hosts:              []string{addr},
+			User:               "user",
+			Password:           "pwd",
+			RootDir:            "atomic",
+			AtomicUpload:       true,
+			DeleteBeforeRename: deleteBeforeRename,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = atomic.Put("refused/a", strings.NewReader("d")); err == nil {
+			t.Errorf("#%d.1: rename over a directory should fail", i)
+		} else if ftp.IsRenameError(err) {
+			t.Errorf("#%d.2: the destination wasn't deleted: %v", i, err)
+		}
+		atomic.Close()
+
+		if _, err = os.Stat(filepath.Join(dir, "a", "keep")); err != nil {
+			t.Errorf("#%d.3: destination removed: %v", i, err)
+		}
+		if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("#%d.4: temporary file left behind: %v", i, entries)
+		}
+	}
+}
+
+func TestLazyDial(t *testing.T) {
+	down, err := ftp.New(ftp.Config{Hosts: []string{"127.0.0.1:1"}})
+	if err != nil {
+		t.Fatal("#1: New should not dial: ", err)
+	}
+	if err = down.Ping(); err == nil {
+		t.Error("#2: Ping should fail")
+	}
+	if err = client.Ping(); err != nil {
+		t.Error("#3: ", err)
+	}
+	if client.Client == nil {
+		t.Error("#3: deprecated Client field not set after dial")
+	}
+
+	down.Close()
+	if _, _, err = down.Stat("a"); err != ftp.ErrClosed {
+		t.Errorf("#4: expected ErrClosed, got %v", err)
+	}
+}
+
+func TestTransferModeConfig(t *testing.T) {
+	if _, err := ftp.New(ftp.Config{Hosts: []string{addr}, ActiveListenAddr: ":0"}); err == nil {
+		t.Error("#1: ActiveListenAddr without ActiveTransfers should fail")
+	}
+	if _, err := ftp.New(ftp.Config{Hosts: []string{addr}, ActiveTransfers: true, ActiveListenAddr: "bad"}); err == nil {
+		t.Error("#2: bad ActiveListenAddr should fail")
+	}
+
+	pasv, err := ftp.New(ftp.Config{Hosts: []string{addr}, User: "user", Password: "pwd", DisableEPSV: true})
+	if err != nil {
+		t.Fatal("#3: ", err)
+	}
+	defer pasv.Close()
+
+	if _, err = pasv.Put("pasv/a", strings.NewReader("d3")); err != nil {
+		t.Fatal("#4: ", err)
+	}
+	if items, err := pasv.List("pasv"); err != nil || len(items) != 1 {
+		t.Errorf("#5: %v %v", items, err)
+	}
+}
+
+func TestActiveTransfers(t *testing.T) {
+	active, err := ftp.New(ftp.Config{
+		Hosts:            []string{addr},
+		User:             "user",
+		Password:         "pwd",
+		ActiveTransfers:  true,
+		ActiveListenAddr: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal("#1: ", err)
+	}
+	defer active.Close()
+
+	if _, err = active.Put("active/a", strings.NewReader("d4")); err != nil {
+		t.Fatal("#2: ", err)
+	}
+	file, err := active.Get("active/a")
+	if err != nil {
+		t.Fatal("#3: ", err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "d4" {
+		t.Errorf("#4: invalid data %q", data)
+	}
+	if items, err := active.List("active"); err != nil || len(items) != 1 {
+		t.Errorf("#5: %v %v", items, err)
+	}
+}
+
+func TestIsDataConnError(t *testing.T) {
+	err := fmt.Errorf("upload: %w", &ftp.DataConnError{Mode: "active", Err: errors.New("refused")})
+	if !ftp.IsDataConnError(err) {
+		t.Error("#1: wrapped DataConnError not detected")
+	}
+	if ftp.IsDataConnError(errors.New("unsupported")) {
+		t.Error("#2: unrelated error detected")
+	}
+}
@@ -0,0 +1,438 @@
+package gcs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+	"github.com/ecletus/oss/internal/httpproxy"
+)
+
+const (
+	defaultAPIEndpoint = "https://storage.googleapis.com"
+	scope              = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+func init() {
+	factories.Registry("gcs", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil {
+			ctx.Var.FormatPathPtr(&cfg.CredentialsFile).
+				FormatPtr(&cfg.Bucket, &cfg.Endpoint.Path, &cfg.Endpoint.Host, &cfg.APIEndpoint)
+		}
+		return New(&cfg)
+	}))
+}
+
+// Config GCS client config
+type Config struct {
+	Bucket string
+	// Service account JSON key. It takes precedence over CredentialsFile.
+	CredentialsJSON string
+	// Path of a service account JSON key file. When both are empty, the
+	// application default credentials are used.
+	CredentialsFile string
+	// Predefined ACL of the uploaded objects, e.g. "publicRead".
+	PredefinedACL string
+	// Public URL of the objects. Default is https://storage.googleapis.com/<Bucket>.
+	Endpoint oss.Endpoint
+	// JSON API base URL, to use emulators. Default is https://storage.googleapis.com.
+	APIEndpoint string
+	// Send unauthenticated requests, to use emulators.
+	NoAuth bool
+	// value in seconds. Expiration of the URLs of SignedURL. Default is one
+	// hour, at most 7 days.
+	SignedURLExpires int64
+}
+
+// Client GCS storage
+type Client struct {
+	Config     *Config
+	HTTPClient *http.Client
+	account    *serviceAccount
+}
+
+var _ oss.MetadataStorageInterface = (*Client)(nil)
+
+// New initialize GCS storage
+func New(config *Config) (client *Client, err error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("gcs: bucket is required")
+	}
+	if config.APIEndpoint == "" {
+		config.APIEndpoint = defaultAPIEndpoint
+	}
+	config.APIEndpoint = strings.TrimSuffix(config.APIEndpoint, "/")
+	if config.Endpoint.Host == "" && config.Endpoint.Path == "" {
+		config.Endpoint = oss.Endpoint{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + config.Bucket}
+	}
+	if config.SignedURLExpires <= 0 {
+		config.SignedURLExpires = 3600
+	}
+
+	client = &Client{Config: config, HTTPClient: http.DefaultClient}
+
+	data := []byte(config.CredentialsJSON)
+	if len(data) == 0 && config.CredentialsFile != "" {
+		if data, err = ioutil.ReadFile(config.CredentialsFile); err != nil {
+			return nil, fmt.Errorf("gcs: read credentials: %v", err)
+		}
+	}
+
+	if len(data) > 0 {
+		if client.account, err = parseServiceAccount(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.NoAuth {
+		return client, nil
+	}
+
+	var creds *google.Credentials
+	if len(data) > 0 {
+		creds, err = google.CredentialsFromJSON(context.Background(), data, scope)
+	} else {
+		creds, err = google.FindDefaultCredentials(context.Background(), scope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gcs: load credentials: %v", err)
+	}
+	client.HTTPClient = oauth2.NewClient(context.Background(), creds.TokenSource)
+	return client, nil
+}
+
+// Error GCS API error
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gcs: %d %s", e.StatusCode, e.Message)
+}
+
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+type object struct {
+	Name               string            `json:"name"`
+	Size               int64             `json:"size,string"`
+	Updated            time.Time         `json:"updated"`
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+}
+
+func (o *object) metadata() *oss.Metadata {
+	m := &oss.Metadata{ContentType: o.ContentType, ContentDisposition: o.ContentDisposition, Custom: o.Metadata}
+	if m.IsZero() {
+		return nil
+	}
+	return m
+}
+
+// ToRelativePath returns the object name of path
+func (client *Client) ToRelativePath(pth string) string {
+	return strings.TrimPrefix(path.Clean("/"+pth), "/")
+}
+
+func escapeName(name string) string {
+	return strings.Replace(url.QueryEscape(name), "+", "%20", -1)
+}
+
+func (client *Client) objectURL(name string) string {
+	return client.Config.APIEndpoint + "/storage/v1/b/" + escapeName(client.Config.Bucket) + "/o/" + escapeName(name)
+}
+
+func (client *Client) do(req *http.Request) (*http.Response, error) {
+	res, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotModified {
+		defer res.Body.Close()
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		if json.Unmarshal(data, &body) != nil || body.Error.Message == "" {
+			body.Error.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &Error{res.StatusCode, body.Error.Message}
+	}
+	return res, nil
+}
+
+func (client *Client) doJSON(method, u string, dst interface{}) error {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if dst == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
+// ServeHTTP streams the object with the media download of the JSON API.
+func (client *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	httpproxy.Serve(w, r, func(method string) (*http.Request, error) {
+		return http.NewRequest(method, client.objectURL(client.ToRelativePath(r.URL.Path))+"?alt=media", nil)
+	}, client.do, IsNotFound)
+}
+
+// Stat receive file stat by path
+func (client *Client) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	var obj object
+	if err = client.doJSON(http.MethodGet, client.objectURL(client.ToRelativePath(pth)), &obj); err != nil {
+		if IsNotFound(err) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	return &fileInfo{&obj}, false, nil
+}
+
+// Get receive file with given path
+func (client *Client) Get(pth string) (file *os.File, err error) {
+	req, err := http.NewRequest(http.MethodGet, client.objectURL(client.ToRelativePath(pth))+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.do(req)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if file, err = oss.NewSpool(); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(file, res.Body); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// Put store a reader into given path
+func (client *Client) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return client.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader into given path with metadata, streaming
+// it in a multipart upload.
+func (client *Client) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	name := client.ToRelativePath(pth)
+	info := map[string]interface{}{"name": name}
+
+	if metadata == nil {
+		metadata = &oss.Metadata{}
+	}
+	contentType := metadata.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	br := bufio.NewReader(reader)
+	if contentType == "" {
+		head, _ := br.Peek(512)
+		contentType = http.DetectContentType(head)
+	}
+	info["contentType"] = contentType
+	if metadata.ContentDisposition != "" {
+		info["contentDisposition"] = metadata.ContentDisposition
+	}
+	if len(metadata.Custom) > 0 {
+		info["metadata"] = metadata.Custom
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+		if err == nil {
+			if err = json.NewEncoder(part).Encode(info); err == nil {
+				if part, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}}); err == nil {
+					if _, err = io.Copy(part, br); err == nil {
+						err = mw.Close()
+					}
+				}
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	q := url.Values{"uploadType": {"multipart"}}
+	if client.Config.PredefinedACL != "" {
+		q.Set("predefinedAcl", client.Config.PredefinedACL)
+	}
+	req, err := http.NewRequest(http.MethodPost, client.Config.APIEndpoint+"/upload/storage/v1/b/"+
+		escapeName(client.Config.Bucket)+"/o?"+q.Encode(), pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+
+	res, err := client.do(req)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var obj object
+	if err = json.NewDecoder(res.Body).Decode(&obj); err != nil {
+		return nil, err
+	}
+	o := client.object(&obj)
+	o.Path = pth
+	return o, nil
+}
+
+func (client *Client) object(obj *object) *oss.Object {
+	updated := obj.Updated
+	return &oss.Object{
+		Path:             "/" + obj.Name,
+		Name:             path.Base(obj.Name),
+		LastModified:     &updated,
+		Metadata:         obj.metadata(),
+		StorageInterface: client,
+	}
+}
+
+// Delete delete file
+func (client *Client) Delete(pth string) error {
+	err := client.doJSON(http.MethodDelete, client.objectURL(client.ToRelativePath(pth)), nil)
+	if IsNotFound(err) {
+		return &os.PathError{Op: "remove", Path: pth, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+// List list all objects under current path
+func (client *Client) List(pth string) (objects []*oss.Object, err error) {
+	var (
+		page      []*oss.Object
+		pageToken string
+	)
+	for {
+		if page, pageToken, err = client.ListPage(pth, pageToken, 0); err != nil {
+			return nil, err
+		}
+		objects = append(objects, page...)
+		if pageToken == "" {
+			return
+		}
+	}
+}
+
+// ListPage list a page of maxResults objects under current path, zero uses
+// the API default. next is the pageToken of the next page, or empty.
+func (client *Client) ListPage(pth, pageToken string, maxResults int) (objects []*oss.Object, next string, err error) {
+	q := url.Values{}
+	if prefix := client.ToRelativePath(pth); prefix != "" {
+		q.Set("prefix", prefix+"/")
+	}
+	if pageToken != "" {
+		q.Set("pageToken", pageToken)
+	}
+	if maxResults > 0 {
+		q.Set("maxResults", strconv.Itoa(maxResults))
+	}
+
+	var res struct {
+		Items         []*object `json:"items"`
+		NextPageToken string    `json:"nextPageToken"`
+	}
+	u := client.Config.APIEndpoint + "/storage/v1/b/" + escapeName(client.Config.Bucket) + "/o?" + q.Encode()
+	if err = client.doJSON(http.MethodGet, u, &res); err != nil {
+		return nil, "", err
+	}
+
+	for _, obj := range res.Items {
+		objects = append(objects, client.object(obj))
+	}
+	return objects, res.NextPageToken, nil
+}
+
+// GetEndpoint get endpoint
+func (client *Client) GetEndpoint() *oss.Endpoint {
+	return &client.Config.Endpoint
+}
+
+func (client *Client) GetURL(p ...string) (url string) {
+	url = client.Config.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = client.Config.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) AssetFS() (assetfs.Interface, error) {
+	return nil, oss.ErrAssetFsUnavailable
+}
+
+type fileInfo struct {
+	obj *object
+}
+
+func (fi *fileInfo) Name() string            { return path.Base(fi.obj.Name) }
+func (fi *fileInfo) Size() int64             { return fi.obj.Size }
+func (*fileInfo) Mode() os.FileMode          { return 0400 }
+func (fi *fileInfo) ModTime() time.Time      { return fi.obj.Updated }
+func (*fileInfo) IsDir() bool                { return false }
+func (*fileInfo) Sys() interface{}           { return nil }
+func (fi *fileInfo) Metadata() *oss.Metadata { return fi.obj.metadata() }
@@ -0,0 +1,269 @@
+package gcs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/tests"
+)
+
+// fakeServer implements the subset of the GCS JSON API used by Client.
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+}
+
+type fakeObject struct {
+	object
+	data []byte
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(p, "/upload/storage/v1/b/") && r.Method == http.MethodPost:
+		s.upload(w, r)
+	case strings.HasPrefix(p, "/storage/v1/b/"):
+		parts := strings.SplitN(strings.TrimPrefix(p, "/storage/v1/b/"), "/", 3)
+		if len(parts) == 2 {
+			s.list(w, r)
+			return
+		}
+		name, _ := url.PathUnescape(parts[2])
+		obj := s.objects[name]
+		if obj == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "No such object: " + name}})
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(s.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Query().Get("alt") == "media":
+			w.Header().Set("Content-Type", obj.ContentType)
+			http.ServeContent(w, r, name, obj.Updated, bytes.NewReader(obj.data))
+		default:
+			json.NewEncoder(w).Encode(obj.object)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeServer) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || r.URL.Query().Get("uploadType") != "multipart" {
+		http.Error(w, "bad upload", http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	var obj fakeObject
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&obj.object)
+	}
+	if err == nil {
+		if part, err = mr.NextPart(); err == nil {
+			obj.data, err = ioutil.ReadAll(part)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	obj.Size = int64(len(obj.data))
+	obj.Updated = time.Now().UTC()
+	s.objects[obj.Name] = &obj
+	json.NewEncoder(w).Encode(obj.object)
+}
+
+func (s *fakeServer) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, q.Get("prefix")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	start, _ := strconv.Atoi(q.Get("pageToken"))
+	max, _ := strconv.Atoi(q.Get("maxResults"))
+	if max == 0 {
+		max = 2
+	}
+
+	res := map[string]interface{}{}
+	var items []object
+	for i := start; i < len(names) && i < start+max; i++ {
+		items = append(items, s.objects[names[i]].object)
+	}
+	if start+max < len(names) {
+		res["nextPageToken"] = strconv.Itoa(start + max)
+	}
+	res["items"] = items
+	json.NewEncoder(w).Encode(res)
+}
+
+func newTestClient(t *testing.T) *Client {
+	server := httptest.NewServer(&fakeServer{objects: map[string]*fakeObject{}})
+	t.Cleanup(server.Close)
+
+	client, err := New(&Config{Bucket: "bucket", APIEndpoint: server.URL, NoAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newTestClient(t), t)
+}
+
+func TestMetadata(t *testing.T) {
+	client := newTestClient(t)
+	metadata := &oss.Metadata{ContentDisposition: "attachment", Custom: map[string]string{"k": "v"}}
+	if _, err := client.PutWithMetadata("/a b/c.txt", strings.NewReader("data"), metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	info, notFound, err := client.Stat("a b/c.txt")
+	if err != nil || notFound {
+		t.Fatalf("bad stat %v %v", notFound, err)
+	}
+	m := oss.GetMetadata(info)
+	if info.Size() != 4 || m == nil || !strings.HasPrefix(m.ContentType, "text/plain") ||
+		m.ContentDisposition != "attachment" || m.Custom["k"] != "v" {
+		t.Errorf("bad stat %v %+v", info.Size(), m)
+	}
+
+	tests.TestServeHTTP(client, "/a%20b/c.txt", "data", t)
+
+	var methods []string
+	client.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		methods = append(methods, req.Method)
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	w := httptest.NewRecorder()
+	client.ServeHTTP(w, httptest.NewRequest("HEAD", "/a%20b/c.txt", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != "4" || w.Body.Len() != 0 ||
+		len(methods) != 1 || methods[0] != "HEAD" {
+		t.Errorf("bad HEAD response %v %v %q, sent %v", w.Code, w.Header(), w.Body.String(), methods)
+	}
+
+	if _, notFound, _ := client.Stat("missing"); !notFound {
+		t.Errorf("expected not found")
+	}
+	if err := client.Delete("missing"); !os.IsNotExist(err) {
+		t.Errorf("Delete: expected not exist, got %v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestListPage(t *testing.T) {
+	client := newTestClient(t)
+	for _, name := range []string{"d/1", "d/2", "d/3", "e/1"} {
+		if _, err := client.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, next, err := client.ListPage("d", "", 2)
+	if err != nil || len(objects) != 2 || next == "" || objects[0].Path != "/d/1" {
+		t.Fatalf("bad first page %v %q %v", objects, next, err)
+	}
+
+	if objects, err = client.List("/d"); err != nil || len(objects) != 3 {
+		t.Errorf("bad list %v %v", objects, err)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "test@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+
+	client, err := New(&Config{Bucket: "bucket", CredentialsJSON: string(creds), NoAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := client.signedURL("GET", "/dir/a b.txt", time.Minute, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Host != "storage.googleapis.com" || u.EscapedPath() != "/bucket/dir/a%20b.txt" ||
+		q.Get("X-Goog-Date") != "20200102T030405Z" || q.Get("X-Goog-Expires") != "60" ||
+		q.Get("X-Goog-Credential") != "test@project.iam.gserviceaccount.com/20200102/auto/storage/goog4_request" ||
+		len(q.Get("X-Goog-Signature")) != 512 {
+		t.Errorf("bad signed URL %v", signed)
+	}
+
+	// the string to sign of the V4 signing process, built by hand
+	canonicalRequest := "GET\n/bucket/dir/a%20b.txt\n" +
+		"X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=test%40project.iam.gserviceaccount.com%2F20200102%2Fauto%2Fstorage%2Fgoog4_request" +
+		"&X-Goog-Date=20200102T030405Z&X-Goog-Expires=60&X-Goog-SignedHeaders=host\n" +
+		"host:storage.googleapis.com\n\nhost\nUNSIGNED-PAYLOAD"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	digest := sha256.Sum256([]byte("GOOG4-RSA-SHA256\n20200102T030405Z\n20200102/auto/storage/goog4_request\n" +
+		hex.EncodeToString(requestHash[:])))
+	signature, err := hex.DecodeString(q.Get("X-Goog-Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("bad signature: %v", err)
+	}
+
+	if signed, err = client.SignedURL("a", 30*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if u, err = url.Parse(signed); err != nil || u.Query().Get("X-Goog-Expires") != "604800" {
+		t.Errorf("expiration not capped %v %v", signed, err)
+	}
+
+	if _, err := newTestClient(t).SignedURL("a", 0); err != ErrSigningUnavailable {
+		t.Errorf("expected ErrSigningUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSigningUnavailable is returned by SignedURL when the client has no
+// service account key.
+var ErrSigningUnavailable = errors.New("gcs: signed URLs require a service account key")
+
+type serviceAccount struct {
+	Email string
+	Key   *rsa.PrivateKey
+}
+
+func parseServiceAccount(data []byte) (*serviceAccount, error) {
+	var v struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("gcs: parse credentials: %v", err)
+	}
+	if v.Type != "service_account" {
+		// user credentials can't sign
+		return nil, nil
+	}
+
+	block, _ := pem.Decode([]byte(v.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("gcs: invalid service account private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("gcs: parse service account private key: %v", err)
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("gcs: service account private key isn't RSA")
+	}
+	return &serviceAccount{v.ClientEmail, rsaKey}, nil
+}
+
+// MaxSignedURLExpires is the longest expiration V4 signatures accept.
+const MaxSignedURLExpires = 7 * 24 * time.Hour
+
+// SignedURL returns a V4 signed GET URL of the object, valid for expires.
+// Zero expires uses Config.SignedURLExpires, longer ones than
+// MaxSignedURLExpires are capped.
+func (client *Client) SignedURL(pth string, expires time.Duration) (string, error) {
+	return client.signedURL("GET", pth, expires, time.Now())
+}
+
+func (client *Client) signedURL(method, pth string, expires time.Duration, now time.Time) (string, error) {
+	if client.account == nil {
+		return "", ErrSigningUnavailable
+	}
+	if expires <= 0 {
+		expires = time.Duration(client.Config.SignedURLExpires) * time.Second
+	}
+	if expires > MaxSignedURLExpires {
+		expires = MaxSignedURLExpires
+	}
+
+	var (
+		host      = "storage.googleapis.com"
+		utc       = now.UTC()
+		datestamp = utc.Format("20060102")
+		scope     = datestamp + "/auto/storage/goog4_request"
+		name      = client.ToRelativePath(pth)
+	)
+
+	segments := strings.Split(client.Config.Bucket+"/"+name, "/")
+	for i, s := range segments {
+		segments[i] = escapeName(s)
+	}
+	canonicalPath := "/" + strings.Join(segments, "/")
+
+	query := url.Values{
+		"X-Goog-Algorithm":     {"GOOG4-RSA-SHA256"},
+		"X-Goog-Credential":    {client.account.Email + "/" + scope},
+		"X-Goog-Date":          {utc.Format("20060102T150405Z")},
+		"X-Goog-Expires":       {strconv.FormatInt(int64(expires/time.Second), 10)},
+		"X-Goog-SignedHeaders": {"host"},
+	}
+	canonicalQuery := canonicalQueryString(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalPath,
+		canonicalQuery,
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		query.Get("X-Goog-Date"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, client.account.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return "https://" + host + canonicalPath + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
+
+// canonicalQueryString sorts and percent encodes the query as RFC 3986.
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, escapeName(k)+"="+escapeName(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,351 @@
+package httpstorage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+	"github.com/ecletus/oss/internal/httpproxy"
+)
+
+func init() {
+	factories.Registry("http", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil {
+			ctx.Var.FormatPathPtr(&cfg.CacheDir).
+				FormatPtr(&cfg.URL, &cfg.Endpoint.Path, &cfg.Endpoint.Host)
+		}
+		return New(&cfg)
+	}))
+}
+
+// Config HTTP origin config
+type Config struct {
+	// Origin base URL, e.g. https://cdn.example.com/assets.
+	URL string
+	// Headers sent with every request, e.g. Authorization.
+	Header map[string]string
+	// Directory of the downloaded files, revalidated with conditional
+	// requests by Get. Default is a temporary directory removed by Close.
+	CacheDir string
+	// Public URL of the files. Default is URL.
+	Endpoint oss.Endpoint
+}
+
+// ErrNoListing is returned by List, wrapped in an *os.PathError: HTTP has
+// no listing.
+var ErrNoListing = errors.New("httpstorage: HTTP origins can't be listed")
+
+// Error unexpected origin response
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("httpstorage: %s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+type cacheEntry struct {
+	etag, lastModified string
+}
+
+// Client read only storage of an HTTP origin
+type Client struct {
+	Config     *Config
+	HTTPClient *http.Client
+
+	base      string
+	cacheDir  string
+	removeDir bool
+	mu        sync.Mutex
+	cache     map[string]*cacheEntry
+}
+
+// New initialize HTTP storage
+func New(config *Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("httpstorage: invalid URL %q", config.URL)
+	}
+	if config.Endpoint.Host == "" && config.Endpoint.Path == "" {
+		config.Endpoint = oss.Endpoint{Scheme: base.Scheme, Host: base.Host, Path: base.Path}
+	}
+
+	client := &Client{
+		Config:     config,
+		HTTPClient: http.DefaultClient,
+		base:       base.String(),
+		cacheDir:   config.CacheDir,
+		cache:      map[string]*cacheEntry{},
+	}
+	if client.cacheDir == "" {
+		if client.cacheDir, err = ioutil.TempDir("", "oss-http"); err != nil {
+			return nil, err
+		}
+		client.removeDir = true
+	} else if err = os.MkdirAll(client.cacheDir, 0755); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Close removes the temporary cache directory. A configured CacheDir is kept.
+func (client *Client) Close() error {
+	if !client.removeDir {
+		return nil
+	}
+	return os.RemoveAll(client.cacheDir)
+}
+
+// ToRelativePath returns the path relative to the origin URL
+func (client *Client) ToRelativePath(pth string) string {
+	return strings.TrimPrefix(path.Clean("/"+pth), "/")
+}
+
+func (client *Client) url(pth string) string {
+	segments := strings.Split(client.ToRelativePath(pth), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return client.base + "/" + strings.Join(segments, "/")
+}
+
+func (client *Client) newRequest(method, pth string) (*http.Request, error) {
+	req, err := http.NewRequest(method, client.url(pth), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range client.Config.Header {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+func isNotFound(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone
+}
+
+// ServeHTTP proxies the origin, its status answers the request.
+func (client *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	httpproxy.Serve(w, r, func(method string) (*http.Request, error) {
+		return client.newRequest(method, r.URL.Path)
+	}, client.HTTPClient.Do, nil)
+}
+
+// Stat receive file stat by path with HEAD. Origins refusing HEAD are asked
+// for the first byte with Range.
+func (client *Client) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	req, err := client.newRequest(http.MethodHead, pth)
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	res.Body.Close()
+
+	size := res.ContentLength
+	if res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented {
+		if req, err = client.newRequest(http.MethodGet, pth); err != nil {
+			return nil, false, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		if res, err = client.HTTPClient.Do(req); err != nil {
+			return nil, false, err
+		}
+		res.Body.Close()
+
+		size = res.ContentLength
+		if res.StatusCode == http.StatusPartialContent {
+			// Content-Range: bytes 0-0/<size>
+			cr := res.Header.Get("Content-Range")
+			if size, err = strconv.ParseInt(cr[strings.LastIndexByte(cr, '/')+1:], 10, 64); err != nil {
+				size = -1
+			}
+		}
+	}
+
+	if isNotFound(res.StatusCode) {
+		return nil, true, nil
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		return nil, false, &Error{req.Method, req.URL.String(), res.StatusCode}
+	}
+
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return &fileInfo{
+		name:        path.Base(client.ToRelativePath(pth)),
+		size:        size,
+		modTime:     modTime,
+		etag:        res.Header.Get("ETag"),
+		contentType: res.Header.Get("Content-Type"),
+	}, false, nil
+}
+
+// Get receive file with given path. The file is downloaded to CacheDir, and
+// revalidated with If-None-Match and If-Modified-Since by the next calls.
+func (client *Client) Get(pth string) (file *os.File, err error) {
+	rel := client.ToRelativePath(pth)
+	sum := sha1.Sum([]byte(rel))
+	cachePath := filepath.Join(client.cacheDir, hex.EncodeToString(sum[:]))
+
+	req, err := client.newRequest(http.MethodGet, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	client.mu.Lock()
+	entry := client.cache[rel]
+	client.mu.Unlock()
+	if entry != nil {
+		if entry.etag != "" {
+			req.Header.Set("If-None-Match", entry.etag)
+		}
+		if entry.lastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.lastModified)
+		}
+	}
+
+	res, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && entry != nil:
+		if file, err = os.Open(cachePath); err == nil {
+			return file, nil
+		}
+		// the cached copy was removed, download it again
+		client.forget(rel)
+		return client.Get(pth)
+	case isNotFound(res.StatusCode):
+		client.forget(rel)
+		return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	case res.StatusCode != http.StatusOK:
+		return nil, &Error{req.Method, req.URL.String(), res.StatusCode}
+	}
+
+	tmp, err := ioutil.TempFile(client.cacheDir, ".download")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(tmp, res.Body); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cachePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	entry = &cacheEntry{etag: res.Header.Get("ETag"), lastModified: res.Header.Get("Last-Modified")}
+	client.mu.Lock()
+	if entry.etag != "" || entry.lastModified != "" {
+		client.cache[rel] = entry
+	} else {
+		delete(client.cache, rel)
+	}
+	client.mu.Unlock()
+
+	return os.Open(cachePath)
+}
+
+func (client *Client) forget(rel string) {
+	client.mu.Lock()
+	delete(client.cache, rel)
+	client.mu.Unlock()
+}
+
+// Put return oss.ErrReadOnly.
+func (client *Client) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return nil, &os.PathError{Op: "put", Path: pth, Err: oss.ErrReadOnly}
+}
+
+// Delete return oss.ErrReadOnly.
+func (client *Client) Delete(pth string) error {
+	return &os.PathError{Op: "remove", Path: pth, Err: oss.ErrReadOnly}
+}
+
+// List return ErrNoListing.
+func (client *Client) List(pth string) ([]*oss.Object, error) {
+	return nil, &os.PathError{Op: "list", Path: pth, Err: ErrNoListing}
+}
+
+// GetEndpoint get endpoint
+func (client *Client) GetEndpoint() *oss.Endpoint {
+	return &client.Config.Endpoint
+}
+
+func (client *Client) GetURL(p ...string) (url string) {
+	url = client.Config.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = client.Config.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) AssetFS() (assetfs.Interface, error) {
+	return nil, oss.ErrAssetFsUnavailable
+}
+
+type fileInfo struct {
+	name        string
+	size        int64
+	modTime     time.Time
+	etag        string
+	contentType string
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (*fileInfo) Mode() os.FileMode     { return 0444 }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (*fileInfo) IsDir() bool           { return false }
+func (*fileInfo) Sys() interface{}      { return nil }
+
+// ETag returns the entity tag sent by the origin.
+func (fi *fileInfo) ETag() string { return fi.etag }
+
+func (fi *fileInfo) Metadata() *oss.Metadata {
+	if fi.contentType == "" {
+		return nil
+	}
+	return &oss.Metadata{ContentType: fi.contentType}
+}
@@ -0,0 +1,161 @@
+package httpstorage
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/tests"
+)
+
+type origin struct {
+	mu       sync.Mutex
+	files    map[string]string
+	noHead   bool
+	requests []string
+}
+
+func (o *origin) set(name, content string) {
+	o.mu.Lock()
+	o.files[name] = content
+	o.mu.Unlock()
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	content, ok := o.files[r.URL.Path]
+	noHead := o.noHead
+	o.requests = append(o.requests, r.Method+" "+r.Header.Get("If-None-Match"))
+	o.mu.Unlock()
+
+	if noHead && r.Method == http.MethodHead {
+		http.Error(w, "no HEAD", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", `"`+content+`"`)
+	http.ServeContent(w, r, r.URL.Path, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), strings.NewReader(content))
+}
+
+func newTestClient(t *testing.T) (*Client, *origin) {
+	o := &origin{files: map[string]string{}}
+	server := httptest.NewServer(o)
+	t.Cleanup(server.Close)
+
+	client, err := New(&Config{URL: server.URL + "/assets", CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, o
+}
+
+func TestGetRevalidates(t *testing.T) {
+	client, o := newTestClient(t)
+	o.set("/assets/a b.txt", "v1")
+
+	for i := 0; i < 2; i++ {
+		file, err := client.Get("/a b.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(file)
+		file.Close()
+		if string(data) != "v1" {
+			t.Errorf("bad content %q", data)
+		}
+	}
+	if got := strings.Join(o.requests, ","); got != `GET ,GET "v1"` {
+		t.Errorf("expected a conditional request, got %v", got)
+	}
+
+	o.set("/assets/a b.txt", "v2")
+	file, err := client.Get("a b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "v2" {
+		t.Errorf("bad content %q", data)
+	}
+
+	if _, err = client.Get("missing"); err == nil || !strings.Contains(err.Error(), "not exist") {
+		t.Errorf("expected not exist, got %v", err)
+	}
+}
+
+func TestTemporaryCacheDir(t *testing.T) {
+	o := &origin{files: map[string]string{"/a.txt": "a"}}
+	server := httptest.NewServer(o)
+	t.Cleanup(server.Close)
+
+	config := &Config{URL: server.URL}
+	client, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CacheDir != "" {
+		t.Errorf("config changed: %q", config.CacheDir)
+	}
+	file, err := client.Get("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(client.cacheDir); !os.IsNotExist(err) {
+		t.Errorf("cache directory not removed: %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	client, o := newTestClient(t)
+	o.set("/assets/a.css", "body{}")
+
+	for _, noHead := range []bool{false, true} {
+		o.mu.Lock()
+		o.noHead = noHead
+		o.mu.Unlock()
+		info, notFound, err := client.Stat("a.css")
+		if err != nil || notFound || info.Size() != 6 || info.ModTime().Year() != 2020 ||
+			info.(*fileInfo).ETag() != `"body{}"` || !strings.HasPrefix(info.(*fileInfo).Metadata().ContentType, "text/css") {
+			t.Errorf("bad stat (noHead %v): %v %v %v", noHead, info, notFound, err)
+		}
+		if _, notFound, err = client.Stat("missing"); !notFound || err != nil {
+			t.Errorf("expected not found (noHead %v), got %v", noHead, err)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	client, o := newTestClient(t)
+	o.set("/assets/a.txt", "content")
+
+	if _, err := client.Put("a.txt", strings.NewReader("x")); !oss.IsErrReadOnly(err) {
+		t.Errorf("expected read only, got %v", err)
+	}
+	if err := client.Delete("a.txt"); !oss.IsErrReadOnly(err) {
+		t.Errorf("expected read only, got %v", err)
+	}
+	if _, err := client.List("/"); !errors.Is(err, ErrNoListing) {
+		t.Errorf("expected no listing, got %v", err)
+	}
+
+	if url := client.GetURL("a.txt"); url != client.base+"/a.txt" {
+		t.Errorf("bad URL %v", url)
+	}
+
+	tests.TestServeHTTP(client, "/a.txt", "content", t)
+}
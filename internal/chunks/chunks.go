@@ -0,0 +1,92 @@
+// Package chunks reads the objects that the database storages keep split in
+// chunks of a fixed size.
+package chunks
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ecletus/oss"
+)
+
+// ErrObjectChanged is returned by the readers of an object replaced or
+// deleted while reading it.
+var ErrObjectChanged = errors.New("oss: object changed while reading")
+
+// Reader reads the chunks of an object on demand, keeping only the current
+// one in memory.
+type Reader struct {
+	size      int64
+	chunkSize int64
+	load      func(seq int64, buf []byte) ([]byte, error)
+	offset    int64
+	seq       int64
+	chunk     []byte
+}
+
+// NewReader returns a reader of an object of size bytes stored in chunks of
+// chunkSize. load returns the chunk seq, it may reuse buf; a nil chunk means
+// that the chunk was removed.
+func NewReader(size int64, chunkSize int, load func(seq int64, buf []byte) ([]byte, error)) *Reader {
+	return &Reader{size: size, chunkSize: int64(chunkSize), load: load, seq: -1}
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	seq := r.offset / r.chunkSize
+	if seq != r.seq {
+		chunk, err := r.load(seq, r.chunk[:0])
+		if err != nil {
+			return 0, err
+		}
+		if chunk == nil {
+			return 0, ErrObjectChanged
+		}
+		r.chunk, r.seq = chunk, seq
+	}
+	start := r.offset - seq*r.chunkSize
+	if start >= int64(len(r.chunk)) {
+		return 0, ErrObjectChanged
+	}
+	n = copy(p, r.chunk[start:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("chunks: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// FileInfo is the os.FileInfo of a stored object.
+type FileInfo struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	metadata *oss.Metadata
+}
+
+func NewFileInfo(name string, size int64, modTime time.Time, metadata *oss.Metadata) *FileInfo {
+	return &FileInfo{name: name, size: size, modTime: modTime, metadata: metadata}
+}
+
+func (fi *FileInfo) Name() string            { return fi.name }
+func (fi *FileInfo) Size() int64             { return fi.size }
+func (*FileInfo) Mode() os.FileMode          { return 0644 }
+func (fi *FileInfo) ModTime() time.Time      { return fi.modTime }
+func (*FileInfo) IsDir() bool                { return false }
+func (*FileInfo) Sys() interface{}           { return nil }
+func (fi *FileInfo) Metadata() *oss.Metadata { return fi.metadata }
@@ -0,0 +1,58 @@
+// Package httpproxy serves the objects of the remote storages through their
+// HTTP APIs.
+package httpproxy
+
+import (
+	"io"
+	"net/http"
+)
+
+var (
+	requestHeaders  = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+	responseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Content-Disposition",
+		"Content-Encoding", "Cache-Control", "Expires", "ETag", "Last-Modified", "Accept-Ranges"}
+)
+
+// Serve answers a GET or HEAD request of an object with the response of the
+// request that newRequest builds for the same method, sent with do. The
+// range and conditional headers of r are forwarded and the entity headers
+// of the response copied back. Errors of do answer 404 when notFound
+// reports them so, 502 otherwise; notFound may be nil.
+func Serve(w http.ResponseWriter, r *http.Request, newRequest func(method string) (*http.Request, error),
+	do func(*http.Request) (*http.Response, error), notFound func(error) bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := newRequest(r.Method)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, h := range requestHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	res, err := do(req.WithContext(r.Context()))
+	if err != nil {
+		if notFound != nil && notFound(err) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	defer res.Body.Close()
+
+	for _, h := range responseHeaders {
+		if v := res.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	if r.Method != http.MethodHead {
+		io.Copy(w, res.Body)
+	}
+}
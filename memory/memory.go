@@ -0,0 +1,305 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+)
+
+func init() {
+	factories.Registry("memory", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil && cfg.Endpoint != nil {
+			ctx.Var.FormatPtr(&cfg.Endpoint.Path, &cfg.Endpoint.Host)
+		}
+		return New(&cfg), nil
+	}))
+}
+
+// ErrSimulatedFailure is returned by the operations failed by FailureRate.
+var ErrSimulatedFailure = errors.New("memory: simulated failure")
+
+type Config struct {
+	Endpoint *oss.Endpoint
+	// value in milliseconds. Every operation sleeps for it.
+	Latency int64
+	// Probability, from 0 to 1, of an operation failing with
+	// ErrSimulatedFailure.
+	FailureRate float64
+	// Fail is called before every operation, op is the method name. A non nil
+	// error fails the operation with it.
+	Fail func(op, path string) error
+}
+
+var _ oss.MetadataStorageInterface = (*Storage)(nil)
+
+type object struct {
+	data     []byte
+	modTime  time.Time
+	metadata *oss.Metadata
+}
+
+// Storage in memory storage, safe for concurrent use
+type Storage struct {
+	Config   Config
+	Endpoint oss.Endpoint
+
+	mu      sync.RWMutex
+	objects map[string]*object
+	assets  oss.AssetExport
+}
+
+// New initialize memory storage
+func New(cfg *Config) *Storage {
+	if cfg.Endpoint == nil {
+		cfg.Endpoint = &oss.Endpoint{Path: "!"}
+	}
+	return &Storage{Config: *cfg, Endpoint: *cfg.Endpoint, objects: map[string]*object{}}
+}
+
+func key(pth string) string {
+	return strings.Trim(path.Clean("/"+pth), "/")
+}
+
+// simulate applies the configured latency and failures to the operation.
+func (this *Storage) simulate(op, pth string) error {
+	if this.Config.Latency > 0 {
+		time.Sleep(time.Duration(this.Config.Latency) * time.Millisecond)
+	}
+	if this.Config.Fail != nil {
+		if err := this.Config.Fail(op, pth); err != nil {
+			return err
+		}
+	}
+	if this.Config.FailureRate > 0 && rand.Float64() < this.Config.FailureRate {
+		return ErrSimulatedFailure
+	}
+	return nil
+}
+
+func (this *Storage) get(pth string) *object {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.objects[key(pth)]
+}
+
+func (this *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := this.simulate("ServeHTTP", r.URL.Path); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	obj := this.get(r.URL.Path)
+	if obj == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if obj.metadata != nil {
+		if obj.metadata.ContentType != "" {
+			w.Header().Set("Content-Type", obj.metadata.ContentType)
+		}
+		if obj.metadata.ContentDisposition != "" {
+			w.Header().Set("Content-Disposition", obj.metadata.ContentDisposition)
+		}
+	}
+	http.ServeContent(w, r, path.Base(r.URL.Path), obj.modTime, bytes.NewReader(obj.data))
+}
+
+func (this *Storage) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	if err = this.simulate("Stat", pth); err != nil {
+		return
+	}
+
+	obj := this.get(pth)
+	if obj == nil {
+		return nil, true, nil
+	}
+	return &fileInfo{path.Base(key(pth)), obj}, false, nil
+}
+
+// Get receive file with given path. The file holds a copy of the object as
+// it was stored, later writes don't change it.
+func (this *Storage) Get(pth string) (file *os.File, err error) {
+	if err = this.simulate("Get", pth); err != nil {
+		return
+	}
+
+	obj := this.get(pth)
+	if obj == nil {
+		return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	}
+
+	if file, err = ioutil.TempFile("", "oss-memory"); err != nil {
+		return
+	}
+	os.Remove(file.Name())
+
+	if _, err = file.Write(obj.data); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return
+}
+
+// Put store a reader into given path
+func (this *Storage) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return this.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader into given path with metadata
+func (this *Storage) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	if err := this.simulate("Put", pth); err != nil {
+		return nil, err
+	}
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.IsZero() {
+		metadata = nil
+	} else {
+		m := *metadata
+		if m.Custom != nil {
+			m.Custom = make(map[string]string, len(metadata.Custom))
+			for k, v := range metadata.Custom {
+				m.Custom[k] = v
+			}
+		}
+		metadata = &m
+	}
+
+	obj := &object{data, time.Now(), metadata}
+
+	this.mu.Lock()
+	this.objects[key(pth)] = obj
+	this.mu.Unlock()
+
+	return this.object(key(pth), obj), nil
+}
+
+func (this *Storage) object(k string, obj *object) *oss.Object {
+	modTime := obj.modTime
+	return &oss.Object{
+		Path:             "/" + k,
+		Name:             path.Base(k),
+		LastModified:     &modTime,
+		Metadata:         obj.metadata,
+		StorageInterface: this,
+	}
+}
+
+// Delete delete file
+func (this *Storage) Delete(pth string) error {
+	if err := this.simulate("Delete", pth); err != nil {
+		return err
+	}
+
+	k := key(pth)
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if _, ok := this.objects[k]; !ok {
+		return &os.PathError{Op: "remove", Path: pth, Err: os.ErrNotExist}
+	}
+	delete(this.objects, k)
+	return nil
+}
+
+// List list all objects under current path
+func (this *Storage) List(pth string) ([]*oss.Object, error) {
+	if err := this.simulate("List", pth); err != nil {
+		return nil, err
+	}
+
+	prefix := key(pth)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	this.mu.RLock()
+	var objects []*oss.Object
+	for k, obj := range this.objects {
+		if strings.HasPrefix(k, prefix) {
+			objects = append(objects, this.object(k, obj))
+		}
+	}
+	this.mu.RUnlock()
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Path < objects[j].Path
+	})
+	return objects, nil
+}
+
+// GetEndpoint get endpoint
+func (this *Storage) GetEndpoint() *oss.Endpoint {
+	return &this.Endpoint
+}
+
+func (this *Storage) GetURL(p ...string) (url string) {
+	url = this.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (this *Storage) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = this.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+// AssetFS exports the objects under "assets", see oss.AssetExport.
+func (this *Storage) AssetFS() (assetfs.Interface, error) {
+	return this.assets.AssetFS(this)
+}
+
+// Close removes the files of AssetFS. The stored objects are kept.
+func (this *Storage) Close() error {
+	return this.assets.Close()
+}
+
+type fileInfo struct {
+	name string
+	obj  *object
+}
+
+func (fi *fileInfo) Name() string            { return fi.name }
+func (fi *fileInfo) Size() int64             { return int64(len(fi.obj.data)) }
+func (*fileInfo) Mode() os.FileMode          { return 0644 }
+func (fi *fileInfo) ModTime() time.Time      { return fi.obj.modTime }
+func (*fileInfo) IsDir() bool                { return false }
+func (*fileInfo) Sys() interface{}           { return nil }
+func (fi *fileInfo) Metadata() *oss.Metadata { return fi.obj.metadata }
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/tests"
+)
+
+func TestAll(t *testing.T) {
+	tests.TestAll(New(&Config{}), t)
+}
+
+func TestMetadataAndServeHTTP(t *testing.T) {
+	storage := New(&Config{})
+	metadata := &oss.Metadata{ContentType: "text/x-b", Custom: map[string]string{"owner": "me"}}
+	if _, err := storage.PutWithMetadata("a/b", strings.NewReader("data"), metadata); err != nil {
+		t.Fatal(err)
+	}
+	metadata.Custom["owner"] = "other"
+
+	info, notFound, err := storage.Stat("/a/b")
+	if err != nil || notFound || info.Size() != 4 || oss.GetMetadata(info).ContentType != "text/x-b" ||
+		oss.GetMetadata(info).Custom["owner"] != "me" {
+		t.Errorf("bad stat %v %v %v", info, notFound, err)
+	}
+
+	w := httptest.NewRecorder()
+	storage.ServeHTTP(w, httptest.NewRequest("GET", "/a/b", nil))
+	if w.Body.String() != "data" || w.Header().Get("Content-Type") != "text/x-b" {
+		t.Errorf("bad response %q %v", w.Body.String(), w.Header())
+	}
+
+	file, err := storage.Get("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "data" {
+		t.Errorf("bad content %q", data)
+	}
+}
+
+func TestSimulatedFailures(t *testing.T) {
+	errPut := errors.New("put failed")
+	storage := New(&Config{Fail: func(op, path string) error {
+		if op == "Put" {
+			return errPut
+		}
+		return nil
+	}})
+	if _, err := storage.Put("a", strings.NewReader("x")); err != errPut {
+		t.Errorf("expected %v, got %v", errPut, err)
+	}
+
+	storage = New(&Config{FailureRate: 1})
+	if _, _, err := storage.Stat("a"); err != ErrSimulatedFailure {
+		t.Errorf("expected ErrSimulatedFailure, got %v", err)
+	}
+}
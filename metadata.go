@@ -0,0 +1,47 @@
+package oss
+
+import (
+	"io"
+	"os"
+)
+
+// Metadata object metadata given at upload time
+type Metadata struct {
+	ContentType        string            `json:",omitempty"`
+	ContentDisposition string            `json:",omitempty"`
+	Custom             map[string]string `json:",omitempty"`
+}
+
+func (m *Metadata) IsZero() bool {
+	return m == nil || (m.ContentType == "" && m.ContentDisposition == "" && len(m.Custom) == 0)
+}
+
+// MetadataStorageInterface is implemented by storages that keep object
+// metadata. Their Stat returns a MetadataFileInfo.
+type MetadataStorageInterface interface {
+	StorageInterface
+	PutWithMetadata(path string, reader io.Reader, metadata *Metadata) (*Object, error)
+}
+
+// MetadataFileInfo file info with the object metadata
+type MetadataFileInfo interface {
+	os.FileInfo
+	Metadata() *Metadata
+}
+
+// PutWithMetadata store reader with metadata if storage keeps it, otherwise
+// store only the reader.
+func PutWithMetadata(storage StorageInterface, path string, reader io.Reader, metadata *Metadata) (*Object, error) {
+	if ms, ok := storage.(MetadataStorageInterface); ok {
+		return ms.PutWithMetadata(path, reader, metadata)
+	}
+	return storage.Put(path, reader)
+}
+
+// GetMetadata returns the metadata of info, or nil.
+func GetMetadata(info os.FileInfo) *Metadata {
+	if mi, ok := info.(MetadataFileInfo); ok {
+		return mi.Metadata()
+	}
+	return nil
+}
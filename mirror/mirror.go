@@ -0,0 +1,339 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+)
+
+type Config struct {
+	// Number of replicas that must accept a write. Zero means all of them.
+	WriteQuorum int
+	// Index of the replica giving the endpoint and the URLs, and read first.
+	Primary int
+	// value in seconds. A replica failing a read is skipped by the next
+	// reads for this time, unless all replicas are failing. Default is 30.
+	RetryAfter int64
+	// OnError is called with every replica error, including the ones of
+	// writes that reached the quorum.
+	OnError func(op, path string, replica int, err error)
+}
+
+// ReplicaError error of one replica
+type ReplicaError struct {
+	Replica int
+	Err     error
+}
+
+func (e *ReplicaError) Error() string {
+	return fmt.Sprintf("replica %d: %v", e.Replica, e.Err)
+}
+
+// Error is returned when a write doesn't reach the quorum or a read fails
+// on the replicas that may have the object. Writes accepted by some
+// replicas aren't rolled back.
+type Error struct {
+	Op        string
+	Path      string
+	Succeeded int
+	Quorum    int
+	Errors    []*ReplicaError
+}
+
+func (e *Error) Error() string {
+	var errs []string
+	for _, err := range e.Errors {
+		errs = append(errs, err.Error())
+	}
+	if e.Quorum > 0 {
+		return fmt.Sprintf("mirror: %s %s: %d of %d required replicas succeeded: %s",
+			e.Op, e.Path, e.Succeeded, e.Quorum, strings.Join(errs, "; "))
+	}
+	return fmt.Sprintf("mirror: %s %s: %s", e.Op, e.Path, strings.Join(errs, "; "))
+}
+
+func IsError(err error) bool {
+	_, ok := err.(*Error)
+	return ok
+}
+
+var _ oss.MetadataStorageInterface = (*Storage)(nil)
+
+// Storage writes to all replicas and reads from the first healthy one.
+type Storage struct {
+	Config   Config
+	Replicas []oss.StorageInterface
+
+	mu       sync.Mutex
+	failedAt []time.Time
+}
+
+// New initialize mirrored storage of replicas
+func New(cfg *Config, replicas ...oss.StorageInterface) (*Storage, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("mirror: no replicas")
+	}
+	config := *cfg
+	cfg = &config
+	if cfg.WriteQuorum <= 0 || cfg.WriteQuorum > len(replicas) {
+		cfg.WriteQuorum = len(replicas)
+	}
+	if cfg.Primary < 0 || cfg.Primary >= len(replicas) {
+		return nil, fmt.Errorf("mirror: primary %d out of range", cfg.Primary)
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = 30
+	}
+	return &Storage{Config: *cfg, Replicas: replicas, failedAt: make([]time.Time, len(replicas))}, nil
+}
+
+func (this *Storage) primary() oss.StorageInterface {
+	return this.Replicas[this.Config.Primary]
+}
+
+func (this *Storage) report(op, pth string, replica int, err error) {
+	if this.Config.OnError != nil {
+		this.Config.OnError(op, pth, replica, err)
+	}
+}
+
+// readOrder returns the replicas to read from: the primary first, the
+// healthy ones before the failing ones.
+func (this *Storage) readOrder() []int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var healthy, failing []int
+	deadline := time.Now().Add(-time.Duration(this.Config.RetryAfter) * time.Second)
+	for i := range this.Replicas {
+		r := (this.Config.Primary + i) % len(this.Replicas)
+		if this.failedAt[r].After(deadline) {
+			failing = append(failing, r)
+		} else {
+			healthy = append(healthy, r)
+		}
+	}
+	return append(healthy, failing...)
+}
+
+func (this *Storage) setHealth(replica int, err error) {
+	this.mu.Lock()
+	if err != nil {
+		this.failedAt[replica] = time.Now()
+	} else {
+		this.failedAt[replica] = time.Time{}
+	}
+	this.mu.Unlock()
+}
+
+// read calls f on the replicas in read order until one finds the object.
+// notFound answers go on to the next replica, since it may have missed the
+// write. If no replica finds it and some failed, the object may be on those,
+// so their errors are returned instead of notFound.
+func (this *Storage) read(op, pth string, f func(replica oss.StorageInterface) (notFound bool, err error)) (notFound bool, err error) {
+	e := &Error{Op: op, Path: pth}
+	notFound = true
+	for _, r := range this.readOrder() {
+		nf, err := f(this.Replicas[r])
+		if err != nil {
+			this.setHealth(r, err)
+			this.report(op, pth, r, err)
+			e.Errors = append(e.Errors, &ReplicaError{r, err})
+			continue
+		}
+		this.setHealth(r, nil)
+		if !nf {
+			return false, nil
+		}
+	}
+	if len(e.Errors) > 0 {
+		return false, e
+	}
+	return true, nil
+}
+
+func (this *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var found oss.StorageInterface
+	notFound, err := this.read("ServeHTTP", r.URL.Path, func(replica oss.StorageInterface) (bool, error) {
+		_, notFound, err := replica.Stat(r.URL.Path)
+		if err == nil && !notFound {
+			found = replica
+		}
+		return notFound, err
+	})
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	case notFound:
+		http.NotFound(w, r)
+	default:
+		found.ServeHTTP(w, r)
+	}
+}
+
+func (this *Storage) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	notFound, err = this.read("Stat", pth, func(replica oss.StorageInterface) (notFound bool, err error) {
+		info, notFound, err = replica.Stat(pth)
+		return
+	})
+	return
+}
+
+func (this *Storage) Get(pth string) (file *os.File, err error) {
+	notFound, err := this.read("Get", pth, func(replica oss.StorageInterface) (bool, error) {
+		f, err := replica.Get(pth)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		file = f
+		return false, nil
+	})
+	if err == nil && notFound {
+		err = &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	}
+	return
+}
+
+// write calls f on all replicas concurrently and checks the quorum.
+func (this *Storage) write(op, pth string, f func(r int, replica oss.StorageInterface) error) error {
+	errs := make([]error, len(this.Replicas))
+	var wg sync.WaitGroup
+	for i, replica := range this.Replicas {
+		wg.Add(1)
+		go func(i int, replica oss.StorageInterface) {
+			defer wg.Done()
+			errs[i] = f(i, replica)
+		}(i, replica)
+	}
+	wg.Wait()
+
+	e := &Error{Op: op, Path: pth, Quorum: this.Config.WriteQuorum}
+	for i, err := range errs {
+		if err != nil {
+			this.report(op, pth, i, err)
+			e.Errors = append(e.Errors, &ReplicaError{i, err})
+		} else {
+			e.Succeeded++
+		}
+	}
+	if e.Succeeded < e.Quorum {
+		return e
+	}
+	return nil
+}
+
+// Put store a reader into all replicas. The reader is spooled to a
+// temporary file read concurrently by the replicas. The returned object is
+// the one of the primary, or of the first replica that succeeded.
+func (this *Storage) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return this.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader with metadata into all replicas. Replicas
+// without metadata support store only the reader.
+func (this *Storage) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	spool, err := ioutil.TempFile("", "oss-mirror")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]*oss.Object, len(this.Replicas))
+	err = this.write("Put", pth, func(r int, replica oss.StorageInterface) (err error) {
+		objects[r], err = oss.PutWithMetadata(replica, pth, io.NewSectionReader(spool, 0, size), metadata)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	object := objects[this.Config.Primary]
+	if object == nil {
+		for _, object = range objects {
+			if object != nil {
+				break
+			}
+		}
+	}
+	result := *object
+	result.StorageInterface = this
+	return &result, nil
+}
+
+// Delete delete file from all replicas. Replicas without the file count as
+// succeeded.
+func (this *Storage) Delete(pth string) error {
+	return this.write("Delete", pth, func(r int, replica oss.StorageInterface) error {
+		if err := replica.Delete(pth); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// List list all objects under current path of the first healthy replica
+func (this *Storage) List(pth string) (objects []*oss.Object, err error) {
+	_, err = this.read("List", pth, func(replica oss.StorageInterface) (bool, error) {
+		list, err := replica.List(pth)
+		if err != nil {
+			return false, err
+		}
+		objects = make([]*oss.Object, len(list))
+		for i, object := range list {
+			o := *object
+			o.StorageInterface = this
+			objects[i] = &o
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// GetEndpoint get endpoint of the primary
+func (this *Storage) GetEndpoint() *oss.Endpoint {
+	return this.primary().GetEndpoint()
+}
+
+func (this *Storage) GetURL(p ...string) string {
+	return this.primary().GetURL(p...)
+}
+
+func (this *Storage) GetDynamicURL(scheme, host string, p ...string) string {
+	return this.primary().GetDynamicURL(scheme, host, p...)
+}
+
+// AssetFS returns the asset file system of the first healthy replica
+// providing one.
+func (this *Storage) AssetFS() (fs assetfs.Interface, err error) {
+	for _, r := range this.readOrder() {
+		if fs, err = this.Replicas[r].AssetFS(); err == nil {
+			return
+		}
+	}
+	return
+}
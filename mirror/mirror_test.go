@@ -0,0 +1,153 @@
+package mirror
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/memory"
+	"github.com/ecletus/oss/tests"
+)
+
+var errDown = errors.New("down")
+
+type replica struct {
+	*memory.Storage
+	mu   sync.Mutex
+	down bool
+}
+
+func newReplica(host string) *replica {
+	r := &replica{}
+	r.Storage = memory.New(&memory.Config{
+		Endpoint: &oss.Endpoint{Scheme: "https", Host: host},
+		Fail: func(op, path string) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.down {
+				return errDown
+			}
+			return nil
+		},
+	})
+	return r
+}
+
+func (r *replica) setDown(down bool) {
+	r.mu.Lock()
+	r.down = down
+	r.mu.Unlock()
+}
+
+func TestAll(t *testing.T) {
+	storage, err := New(&Config{}, newReplica("a"), newReplica("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests.TestAll(storage, t)
+}
+
+func TestWriteQuorum(t *testing.T) {
+	replicas := []*replica{newReplica("a"), newReplica("b"), newReplica("c")}
+	var (
+		mu     sync.Mutex
+		failed []int
+	)
+	storage, err := New(&Config{WriteQuorum: 2, Primary: 1, OnError: func(op, path string, r int, err error) {
+		mu.Lock()
+		failed = append(failed, r)
+		mu.Unlock()
+	}}, replicas[0], replicas[1], replicas[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicas[2].setDown(true)
+	object, err := storage.Put("a.txt", strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.StorageInterface != storage || len(failed) != 1 || failed[0] != 2 {
+		t.Errorf("bad put %v, failed %v", object, failed)
+	}
+	if _, notFound, _ := replicas[0].Stat("a.txt"); notFound {
+		t.Errorf("replica 0 should have the file")
+	}
+
+	replicas[1].setDown(true)
+	_, err = storage.Put("b.txt", strings.NewReader("content"))
+	e, ok := err.(*Error)
+	if !ok || e.Succeeded != 1 || e.Quorum != 2 || len(e.Errors) != 2 || e.Errors[0].Replica != 1 || e.Errors[0].Err != errDown {
+		t.Errorf("expected quorum error, got %v", err)
+	}
+
+	// replica 2 missed a.txt
+	replicas[1].setDown(false)
+	replicas[2].setDown(false)
+	if err = storage.Delete("a.txt"); err != nil {
+		t.Errorf("delete should ignore missing files, got %v", err)
+	}
+}
+
+func TestReadFailover(t *testing.T) {
+	a, b := newReplica("a"), newReplica("b")
+	cfg := &Config{}
+	storage, err := New(cfg, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.WriteQuorum != 0 || cfg.RetryAfter != 0 {
+		t.Errorf("config of the caller changed: %+v", cfg)
+	}
+	if _, err = storage.Put("a.txt", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	a.setDown(true)
+	file, err := storage.Get("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "content" {
+		t.Errorf("bad content %q", data)
+	}
+	if order := storage.readOrder(); order[0] != 1 {
+		t.Errorf("failing primary should be read last, got %v", order)
+	}
+
+	w := httptest.NewRecorder()
+	storage.ServeHTTP(w, httptest.NewRequest("GET", "/a.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "content" {
+		t.Errorf("bad response %v %q", w.Code, w.Body.String())
+	}
+
+	if objects, err := storage.List("/"); err != nil || len(objects) != 1 {
+		t.Errorf("bad list %v %v", objects, err)
+	}
+
+	b.setDown(true)
+	if _, _, err = storage.Stat("a.txt"); !IsError(err) {
+		t.Errorf("expected mirror error, got %v", err)
+	}
+	b.setDown(false)
+
+	// an object missing on the healthy replicas may be on the failing one
+	if _, notFound, err := storage.Stat("b.txt"); notFound || !IsError(err) {
+		t.Errorf("expected mirror error, got %v %v", notFound, err)
+	}
+	if _, err = storage.Get("b.txt"); os.IsNotExist(err) || !IsError(err) {
+		t.Errorf("expected mirror error, got %v", err)
+	}
+
+	// URLs always come from the primary
+	if url := storage.GetURL("a.txt"); url != "https://a/a.txt" {
+		t.Errorf("bad URL %v", url)
+	}
+}
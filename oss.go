@@ -75,6 +75,7 @@ type Object struct {
 	Path             string
 	Name             string
 	LastModified     *time.Time
+	Metadata         *Metadata
 	StorageInterface StorageInterface
 }
 
@@ -0,0 +1,288 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+)
+
+// WhiteoutPrefix prefixes the name of the markers hiding deleted objects of
+// the lower layers.
+const WhiteoutPrefix = ".wh."
+
+// ErrReservedName is returned by the writes of names starting with
+// WhiteoutPrefix.
+var ErrReservedName = errors.New("overlay: names starting with " + WhiteoutPrefix + " are reserved")
+
+type Config struct {
+	// Copy the objects read with Get or ServeHTTP from a lower layer to the
+	// top layer.
+	CopyUp bool
+	// OnCopyUpError is called when copying up fails. The read succeeds
+	// anyway.
+	OnCopyUpError func(path string, err error)
+}
+
+var _ oss.MetadataStorageInterface = (*Storage)(nil)
+
+// Storage layered storage. Reads try the layers in order, writes go to the
+// first one only. Objects deleted while present in a lower layer are hidden
+// by a whiteout marker written to the top layer.
+type Storage struct {
+	Config Config
+	Layers []oss.StorageInterface
+
+	assets oss.AssetExport
+}
+
+// New initialize the overlay of layers, the first one is the top.
+func New(cfg *Config, layers ...oss.StorageInterface) (*Storage, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("overlay: no layers")
+	}
+	return &Storage{Config: *cfg, Layers: layers}, nil
+}
+
+func (this *Storage) top() oss.StorageInterface {
+	return this.Layers[0]
+}
+
+func clean(pth string) string {
+	return path.Clean("/" + pth)
+}
+
+func whiteoutPath(pth string) string {
+	dir, name := path.Split(clean(pth))
+	return dir + WhiteoutPrefix + name
+}
+
+func isWhiteout(pth string) bool {
+	return strings.HasPrefix(path.Base(pth), WhiteoutPrefix)
+}
+
+// lookup returns the index of the layer having pth and its info, or -1 when
+// no layer has it or a whiteout hides it.
+func (this *Storage) lookup(pth string) (layer int, info os.FileInfo, err error) {
+	return this.lookupFrom(0, pth)
+}
+
+// lookupFrom is lookup skipping the layers above from.
+func (this *Storage) lookupFrom(from int, pth string) (layer int, info os.FileInfo, err error) {
+	if isWhiteout(pth) {
+		return -1, nil, nil
+	}
+	for i := from; i < len(this.Layers); i++ {
+		l := this.Layers[i]
+		info, notFound, err := l.Stat(pth)
+		if err != nil {
+			return -1, nil, err
+		}
+		if !notFound {
+			return i, info, nil
+		}
+		if i == len(this.Layers)-1 {
+			break
+		}
+		if _, notFound, err = l.Stat(whiteoutPath(pth)); err != nil {
+			return -1, nil, err
+		} else if !notFound {
+			return -1, nil, nil
+		}
+	}
+	return -1, nil, nil
+}
+
+// copyUp copies pth from layer to the top layer and reports whether it
+// succeeded.
+func (this *Storage) copyUp(pth string, layer int, info os.FileInfo) bool {
+	err := func() error {
+		src, err := this.Layers[layer].Get(pth)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			src.Close()
+			oss.RemoveSpool(src)
+		}()
+		_, err = this.put(pth, src, oss.GetMetadata(info))
+		return err
+	}()
+	if err != nil && this.Config.OnCopyUpError != nil {
+		this.Config.OnCopyUpError(pth, err)
+	}
+	return err == nil
+}
+
+func (this *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	layer, info, err := this.lookup(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if layer < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if layer > 0 && this.Config.CopyUp && this.copyUp(r.URL.Path, layer, info) {
+		layer = 0
+	}
+	this.Layers[layer].ServeHTTP(w, r)
+}
+
+func (this *Storage) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	layer, info, err := this.lookup(pth)
+	if err != nil {
+		return nil, false, err
+	}
+	return info, layer < 0, nil
+}
+
+func (this *Storage) Get(pth string) (*os.File, error) {
+	layer, info, err := this.lookup(pth)
+	if err != nil {
+		return nil, err
+	}
+	if layer < 0 {
+		return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	}
+	if layer > 0 && this.Config.CopyUp && this.copyUp(pth, layer, info) {
+		layer = 0
+	}
+	return this.Layers[layer].Get(pth)
+}
+
+// Put store a reader into the top layer, removing its whiteout.
+func (this *Storage) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return this.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader with metadata into the top layer, removing
+// its whiteout.
+func (this *Storage) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	object, err := this.put(pth, reader, metadata)
+	if err != nil {
+		return nil, err
+	}
+	result := *object
+	result.StorageInterface = this
+	return &result, nil
+}
+
+func (this *Storage) put(pth string, reader io.Reader, metadata *oss.Metadata) (*oss.Object, error) {
+	if isWhiteout(pth) {
+		return nil, &os.PathError{Op: "put", Path: pth, Err: ErrReservedName}
+	}
+	object, err := oss.PutWithMetadata(this.top(), pth, reader, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(this.Layers) > 1 {
+		if err = this.top().Delete(whiteoutPath(pth)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return object, nil
+}
+
+// Delete delete file from the top layer. A whiteout is written when a lower
+// layer has the file.
+func (this *Storage) Delete(pth string) error {
+	layer, _, err := this.lookup(pth)
+	if err != nil {
+		return err
+	}
+	if layer < 0 {
+		return &os.PathError{Op: "remove", Path: pth, Err: os.ErrNotExist}
+	}
+	lower := layer
+	if layer == 0 && len(this.Layers) > 1 {
+		if lower, _, err = this.lookupFrom(1, pth); err != nil {
+			return err
+		}
+	}
+	// the whiteout is written first, so that a failure never reveals the
+	// lower copy
+	if lower > 0 {
+		if _, err = this.top().Put(whiteoutPath(pth), strings.NewReader("")); err != nil {
+			return err
+		}
+	}
+	if layer == 0 {
+		return this.top().Delete(pth)
+	}
+	return nil
+}
+
+// List list the objects under current path of all layers. Objects of upper
+// layers replace the ones of lower layers, and whiteouts hide them.
+func (this *Storage) List(pth string) (objects []*oss.Object, err error) {
+	var (
+		seen   = map[string]bool{}
+		hidden = map[string]bool{}
+	)
+	for _, l := range this.Layers {
+		list, err := l.List(pth)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		var whiteouts []string
+		for _, object := range list {
+			p := clean(object.Path)
+			if isWhiteout(p) {
+				dir, name := path.Split(p)
+				whiteouts = append(whiteouts, dir+strings.TrimPrefix(name, WhiteoutPrefix))
+				continue
+			}
+			if seen[p] || hidden[p] {
+				continue
+			}
+			seen[p] = true
+			o := *object
+			o.StorageInterface = this
+			objects = append(objects, &o)
+		}
+		// whiteouts hide the lower layers only
+		for _, p := range whiteouts {
+			hidden[p] = true
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Path < objects[j].Path
+	})
+	return objects, nil
+}
+
+// GetEndpoint get endpoint of the top layer
+func (this *Storage) GetEndpoint() *oss.Endpoint {
+	return this.top().GetEndpoint()
+}
+
+func (this *Storage) GetURL(p ...string) string {
+	return this.top().GetURL(p...)
+}
+
+func (this *Storage) GetDynamicURL(scheme, host string, p ...string) string {
+	return this.top().GetDynamicURL(scheme, host, p...)
+}
+
+// AssetFS exports the merged assets of the layers, whiteouts applied. See
+// oss.AssetExport.
+func (this *Storage) AssetFS() (assetfs.Interface, error) {
+	return this.assets.AssetFS(this)
+}
+
+// Close removes the files of AssetFS. The layers aren't closed.
+func (this *Storage) Close() error {
+	return this.assets.Close()
+}
@@ -0,0 +1,243 @@
+package overlay
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/memory"
+	"github.com/ecletus/oss/tests"
+)
+
+func newTestStorage(t *testing.T, cfg *Config) (storage *Storage, top, lower *memory.Storage) {
+	top, lower = memory.New(&memory.Config{}), memory.New(&memory.Config{})
+	for name, content := range map[string]string{"a.txt": "old a", "dir/b.txt": "old b", "dir/c.txt": "old c"} {
+		if _, err := lower.Put(name, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage, err := New(cfg, top, lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func content(t *testing.T, storage oss.StorageInterface, pth string) string {
+	file, err := storage.Get(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, _ := ioutil.ReadAll(file)
+	return string(data)
+}
+
+func paths(objects []*oss.Object) string {
+	var p []string
+	for _, o := range objects {
+		p = append(p, o.Path)
+	}
+	return strings.Join(p, ",")
+}
+
+func TestAll(t *testing.T) {
+	storage, _, _ := newTestStorage(t, &Config{})
+	tests.TestAll(storage, t)
+}
+
+func TestReadThrough(t *testing.T) {
+	storage, top, _ := newTestStorage(t, &Config{})
+	if got := content(t, storage, "a.txt"); got != "old a" {
+		t.Errorf("bad content %q", got)
+	}
+	if _, notFound, _ := top.Stat("a.txt"); !notFound {
+		t.Errorf("reads should not copy up")
+	}
+
+	if _, err := storage.Put("dir/b.txt", strings.NewReader("new b")); err != nil {
+		t.Fatal(err)
+	}
+	if got := content(t, storage, "dir/b.txt"); got != "new b" {
+		t.Errorf("bad content %q", got)
+	}
+
+	objects, err := storage.List("dir")
+	if err != nil || paths(objects) != "/dir/b.txt,/dir/c.txt" {
+		t.Errorf("bad list %v %v", paths(objects), err)
+	}
+
+	w := httptest.NewRecorder()
+	storage.ServeHTTP(w, httptest.NewRequest("GET", "/dir/c.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "old c" {
+		t.Errorf("bad response %v %q", w.Code, w.Body.String())
+	}
+}
+
+func TestWhiteouts(t *testing.T) {
+	storage, top, lower := newTestStorage(t, &Config{})
+
+	if err := storage.Delete("dir/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, notFound, _ := storage.Stat("dir/c.txt"); !notFound {
+		t.Errorf("deleted file should be hidden")
+	}
+	if _, notFound, _ := lower.Stat("dir/c.txt"); notFound {
+		t.Errorf("lower layer should be untouched")
+	}
+	if objects, _ := storage.List("/"); paths(objects) != "/a.txt,/dir/b.txt" {
+		t.Errorf("bad list %v", paths(objects))
+	}
+
+	// a file in both layers
+	storage.Put("a.txt", strings.NewReader("new a"))
+	if err := storage.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, notFound, _ := storage.Stat("a.txt"); !notFound {
+		t.Errorf("deleted file should be hidden")
+	}
+
+	if err := storage.Delete("a.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	if _, err := storage.Put("dir/c.txt", strings.NewReader("new c")); err != nil {
+		t.Fatal(err)
+	}
+	if got := content(t, storage, "dir/c.txt"); got != "new c" {
+		t.Errorf("bad content %q", got)
+	}
+	if objects, _ := top.List("/"); paths(objects) != "/.wh.a.txt,/dir/c.txt" {
+		t.Errorf("bad top layer %v", paths(objects))
+	}
+}
+
+func TestWhiteoutFailure(t *testing.T) {
+	var failWhiteout bool
+	top := memory.New(&memory.Config{Fail: func(op, pth string) error {
+		if failWhiteout && isWhiteout(pth) {
+			return errors.New("whiteout failed")
+		}
+		return nil
+	}})
+	lower := memory.New(&memory.Config{})
+	storage, err := New(&Config{}, top, lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower.Put("a.txt", strings.NewReader("old a"))
+	storage.Put("a.txt", strings.NewReader("new a"))
+
+	// the copy of the top layer stays, the lower one is never revealed
+	failWhiteout = true
+	if err = storage.Delete("a.txt"); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := content(t, storage, "a.txt"); got != "new a" {
+		t.Errorf("bad content %q", got)
+	}
+
+	failWhiteout = false
+	if err = storage.Delete("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, notFound, _ := storage.Stat("a.txt"); !notFound {
+		t.Errorf("deleted file should be hidden")
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	storage, top, _ := newTestStorage(t, &Config{})
+	if _, err := storage.Put("dir/.wh.c.txt", strings.NewReader("")); !errors.Is(err, ErrReservedName) {
+		t.Errorf("expected ErrReservedName, got %v", err)
+	}
+	if _, notFound, _ := top.Stat("dir/.wh.c.txt"); !notFound {
+		t.Errorf("whiteout should not be written")
+	}
+	if _, notFound, _ := storage.Stat("dir/c.txt"); notFound {
+		t.Errorf("file should not be hidden")
+	}
+}
+
+func TestCopyUp(t *testing.T) {
+	storage, top, lower := newTestStorage(t, &Config{CopyUp: true})
+	lower.PutWithMetadata("meta.txt", strings.NewReader("m"), &oss.Metadata{ContentType: "text/x-m"})
+
+	if got := content(t, storage, "meta.txt"); got != "m" {
+		t.Errorf("bad content %q", got)
+	}
+	info, notFound, _ := top.Stat("meta.txt")
+	if notFound || oss.GetMetadata(info).ContentType != "text/x-m" {
+		t.Errorf("file should be copied up with its metadata")
+	}
+
+	w := httptest.NewRecorder()
+	storage.ServeHTTP(w, httptest.NewRequest("GET", "/a.txt", nil))
+	if _, notFound, _ := top.Stat("a.txt"); notFound || w.Body.String() != "old a" {
+		t.Errorf("served file should be copied up")
+	}
+}
+
+// countingStorage counts the reads of a layer.
+type countingStorage struct {
+	oss.StorageInterface
+	gets, serves int
+}
+
+func (s *countingStorage) Get(pth string) (*os.File, error) {
+	s.gets++
+	return s.StorageInterface.Get(pth)
+}
+
+func (s *countingStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serves++
+	s.StorageInterface.ServeHTTP(w, r)
+}
+
+func TestCopyUpServesTopLayer(t *testing.T) {
+	top, lower := memory.New(&memory.Config{}), &countingStorage{StorageInterface: memory.New(&memory.Config{})}
+	lower.Put("a.txt", strings.NewReader("a"))
+	lower.Put("b.txt", strings.NewReader("b"))
+	storage, err := New(&Config{CopyUp: true}, top, lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := content(t, storage, "a.txt"); got != "a" || lower.gets != 1 {
+		t.Errorf("got %q after %d reads of the lower layer", got, lower.gets)
+	}
+	w := httptest.NewRecorder()
+	storage.ServeHTTP(w, httptest.NewRequest("GET", "/b.txt", nil))
+	if w.Body.String() != "b" || lower.serves != 0 {
+		t.Errorf("got %q after %d serves of the lower layer", w.Body.String(), lower.serves)
+	}
+}
+
+func TestCopyUpRemovesSpools(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	top, lower := memory.New(&memory.Config{}), memory.New(&memory.Config{})
+	lower.Put("a.txt", strings.NewReader("a"))
+	storage, err := New(&Config{CopyUp: true}, top, tests.SpoolStorage{StorageInterface: lower})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	storage.ServeHTTP(w, httptest.NewRequest("GET", "/a.txt", nil))
+	if _, notFound, _ := top.Stat("a.txt"); notFound || w.Body.String() != "a" {
+		t.Errorf("served file should be copied up")
+	}
+	if spools, _ := filepath.Glob(filepath.Join(tmp, "*")); len(spools) != 0 {
+		t.Errorf("spools not removed: %v", spools)
+	}
+}
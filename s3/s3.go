@@ -104,7 +104,7 @@ func (client Client) Get(path string) (file *os.File, err error) {
 	})
 
 	if err == nil {
-		if file, err = ioutil.TempFile("/tmp", "s3"); err == nil {
+		if file, err = oss.NewSpool(); err == nil {
 			_, err = io.Copy(file, getResponse.Body)
 			file.Seek(0, 0)
 		}
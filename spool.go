@@ -0,0 +1,37 @@
+package oss
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The storages that download the objects return Get as a temporary file
+// owned by the caller. It's created by NewSpool, so callers that don't know
+// the storage can remove it with RemoveSpool once done, while the files of
+// the local storages are left alone.
+
+const spoolPrefix = "oss-spool-"
+
+// NewSpool creates a temporary file for the content returned by Get.
+func NewSpool() (*os.File, error) {
+	return ioutil.TempFile("", spoolPrefix)
+}
+
+// IsSpool reports whether file was created by NewSpool.
+func IsSpool(file *os.File) bool {
+	dir, name := filepath.Split(file.Name())
+	return filepath.Clean(dir) == filepath.Clean(os.TempDir()) && strings.HasPrefix(name, spoolPrefix)
+}
+
+// RemoveSpool removes file if it was created by NewSpool.
+func RemoveSpool(file *os.File) error {
+	if !IsSpool(file) {
+		return nil
+	}
+	if err := os.Remove(file.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,544 @@
+package sqlstorage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+	"github.com/ecletus/oss/internal/chunks"
+)
+
+func init() {
+	factories.Registry("sql", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil {
+			ctx.Var.FormatPtr(&cfg.DSN)
+			if cfg.Endpoint != nil {
+				ctx.Var.FormatPtr(&cfg.Endpoint.Path, &cfg.Endpoint.Host)
+			}
+		}
+		return Open(&cfg)
+	}))
+}
+
+const (
+	defaultChunkSize     = 256 << 10
+	defaultUploadTimeout = 24 * 60 * 60
+)
+
+// ErrObjectChanged is returned when the row being read is replaced.
+var ErrObjectChanged = chunks.ErrObjectChanged
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+type Config struct {
+	// database/sql driver name, the driver must be imported by the
+	// application. Default is "sqlite3".
+	Driver string
+	DSN    string
+	// Table of the objects, optionally qualified by the schema. The chunks are
+	// kept in <Table>_chunks and the uploads in progress in <Table>_pending.
+	// Default is "oss_objects".
+	Table string
+	// value in bytes. Size of the stored chunks. Default is 256KiB.
+	ChunkSize int
+	// value in seconds. Uploads started earlier are considered interrupted
+	// by New, which removes their chunks. Default is one day.
+	UploadTimeout int
+	Endpoint      *oss.Endpoint
+}
+
+var _ oss.MetadataStorageInterface = (*Storage)(nil)
+
+// Storage database storage. Objects are rows of Table and their content is
+// split in chunks of ChunkSize, written and read one chunk at a time. The
+// chunks left without a row by interrupted writes are removed by New.
+type Storage struct {
+	Config   Config
+	Endpoint oss.Endpoint
+	DB       *sql.DB
+
+	postgres bool
+	closeDB  bool
+	assets   oss.AssetExport
+}
+
+// Open opens the database of Config.DSN and creates the tables.
+func Open(cfg *Config) (*Storage, error) {
+	if cfg.Driver == "" {
+		cfg.Driver = "sqlite3"
+	}
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := New(db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	storage.closeDB = true
+	return storage, nil
+}
+
+// New initialize the storage on db and creates the tables.
+func New(db *sql.DB, cfg *Config) (*Storage, error) {
+	if cfg.Table == "" {
+		cfg.Table = "oss_objects"
+	} else if !tableName.MatchString(cfg.Table) {
+		return nil, fmt.Errorf("sqlstorage: invalid table name %q", cfg.Table)
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+	if cfg.UploadTimeout <= 0 {
+		cfg.UploadTimeout = defaultUploadTimeout
+	}
+	if cfg.Endpoint == nil {
+		cfg.Endpoint = &oss.Endpoint{Path: "!"}
+	}
+
+	this := &Storage{Config: *cfg, Endpoint: *cfg.Endpoint, DB: db}
+	switch cfg.Driver {
+	case "postgres", "pgx":
+		this.postgres = true
+	}
+	if err := this.createTables(); err != nil {
+		return nil, fmt.Errorf("sqlstorage: create tables: %v", err)
+	}
+	if err := this.removeOrphans(); err != nil {
+		return nil, fmt.Errorf("sqlstorage: remove orphan chunks: %v", err)
+	}
+	return this, nil
+}
+
+func (this *Storage) createTables() error {
+	blob := "BLOB"
+	switch {
+	case this.postgres:
+		blob = "BYTEA"
+	case this.Config.Driver == "mysql":
+		blob = "LONGBLOB"
+	}
+
+	for _, query := range []string{
+		`CREATE TABLE IF NOT EXISTS ` + this.Config.Table + ` (
+			path VARCHAR(512) NOT NULL PRIMARY KEY,
+			version VARCHAR(32) NOT NULL,
+			size BIGINT NOT NULL,
+			chunk_size INTEGER NOT NULL,
+			content_type VARCHAR(255) NOT NULL,
+			mtime BIGINT NOT NULL,
+			metadata TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + this.Config.Table + `_chunks (
+			version VARCHAR(32) NOT NULL,
+			seq INTEGER NOT NULL,
+			data ` + blob + ` NOT NULL,
+			PRIMARY KEY (version, seq)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + this.Config.Table + `_pending (
+			version VARCHAR(32) NOT NULL PRIMARY KEY,
+			started BIGINT NOT NULL
+		)`,
+	} {
+		if _, err := this.DB.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOrphans removes the chunks of the interrupted uploads and of the
+// versions whose rows were replaced or deleted without removing them.
+func (this *Storage) removeOrphans() error {
+	table := this.Config.Table
+	started := time.Now().Add(-time.Duration(this.Config.UploadTimeout) * time.Second).UnixNano()
+	if _, err := this.DB.Exec(this.rebind(`DELETE FROM `+table+`_pending WHERE started < ?`), started); err != nil {
+		return err
+	}
+	_, err := this.DB.Exec(`DELETE FROM ` + table + `_chunks WHERE version NOT IN (SELECT version FROM ` + table + `)
+		AND version NOT IN (SELECT version FROM ` + table + `_pending)`)
+	return err
+}
+
+// rebind rewrites the "?" placeholders of query for the driver.
+func (this *Storage) rebind(query string) string {
+	if !this.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func key(pth string) string {
+	return strings.Trim(path.Clean("/"+pth), "/")
+}
+
+type row struct {
+	path        string
+	version     string
+	size        int64
+	chunkSize   int
+	contentType string
+	mtime       time.Time
+	metadata    *oss.Metadata
+}
+
+func (r *row) fullMetadata() *oss.Metadata {
+	m := &oss.Metadata{ContentType: r.contentType}
+	if r.metadata != nil {
+		m.ContentDisposition, m.Custom = r.metadata.ContentDisposition, r.metadata.Custom
+	}
+	if m.IsZero() {
+		return nil
+	}
+	return m
+}
+
+const columns = "path, version, size, chunk_size, content_type, mtime, metadata"
+
+func scanRow(scanner interface{ Scan(...interface{}) error }) (*row, error) {
+	var (
+		r        row
+		mtime    int64
+		metadata string
+	)
+	if err := scanner.Scan(&r.path, &r.version, &r.size, &r.chunkSize, &r.contentType, &mtime, &metadata); err != nil {
+		return nil, err
+	}
+	r.mtime = time.Unix(0, mtime)
+	if metadata != "" && metadata != "{}" {
+		r.metadata = &oss.Metadata{}
+		if err := json.Unmarshal([]byte(metadata), r.metadata); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+func (this *Storage) get(pth string) (*row, error) {
+	r, err := scanRow(this.DB.QueryRow(this.rebind(`SELECT `+columns+` FROM `+this.Config.Table+` WHERE path = ?`), key(pth)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+func (this *Storage) newReader(r *row) *chunks.Reader {
+	query := this.rebind(`SELECT data FROM ` + this.Config.Table + `_chunks WHERE version = ? AND seq = ?`)
+	return chunks.NewReader(r.size, r.chunkSize, func(seq int64, buf []byte) (chunk []byte, err error) {
+		if err = this.DB.QueryRow(query, r.version, seq).Scan(&chunk); err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return
+	})
+}
+
+func (this *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	obj, err := this.get(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if obj == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if obj.contentType != "" {
+		w.Header().Set("Content-Type", obj.contentType)
+	}
+	if obj.metadata != nil && obj.metadata.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", obj.metadata.ContentDisposition)
+	}
+	http.ServeContent(w, r, path.Base(obj.path), obj.mtime, this.newReader(obj))
+}
+
+func (this *Storage) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	obj, err := this.get(pth)
+	if err != nil {
+		return nil, false, err
+	}
+	if obj == nil {
+		return nil, true, nil
+	}
+	return chunks.NewFileInfo(path.Base(obj.path), obj.size, obj.mtime, obj.fullMetadata()), false, nil
+}
+
+// Get receive file with given path. The chunks of the stored version are
+// read one query at a time into an unlinked temporary file, ErrObjectChanged
+// is returned if the object is replaced meanwhile.
+func (this *Storage) Get(pth string) (file *os.File, err error) {
+	obj, err := this.get(pth)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+	}
+
+	if file, err = ioutil.TempFile("", "oss-sql"); err != nil {
+		return
+	}
+	os.Remove(file.Name())
+
+	if _, err = io.Copy(file, this.newReader(obj)); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return
+}
+
+// Put store a reader into given path
+func (this *Storage) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	return this.PutWithMetadata(pth, reader, nil)
+}
+
+// PutWithMetadata store a reader into given path with metadata. The reader
+// is written one chunk at a time under a new version, the row is switched to
+// it once all chunks are stored.
+func (this *Storage) PutWithMetadata(pth string, reader io.Reader, metadata *oss.Metadata) (_ *oss.Object, err error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	obj := &row{path: key(pth), version: newVersion(), chunkSize: this.Config.ChunkSize, mtime: time.Now()}
+	if !metadata.IsZero() {
+		obj.contentType = metadata.ContentType
+		if metadata.ContentDisposition != "" || len(metadata.Custom) > 0 {
+			obj.metadata = &oss.Metadata{ContentDisposition: metadata.ContentDisposition, Custom: metadata.Custom}
+		}
+	}
+	if obj.contentType == "" {
+		obj.contentType = mime.TypeByExtension(path.Ext(obj.path))
+	}
+	metadataJSON := []byte("{}")
+	if obj.metadata != nil {
+		if metadataJSON, err = json.Marshal(obj.metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	// the chunks of a pending version are kept by removeOrphans until the
+	// row points to it
+	if _, err = this.DB.Exec(this.rebind(`INSERT INTO `+this.Config.Table+`_pending (version, started) VALUES (?, ?)`),
+		obj.version, obj.mtime.UnixNano()); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			this.deleteChunks(obj.version)
+		}
+		this.DB.Exec(this.rebind(`DELETE FROM `+this.Config.Table+`_pending WHERE version = ?`), obj.version)
+	}()
+
+	insertChunk := this.rebind(`INSERT INTO ` + this.Config.Table + `_chunks (version, seq, data) VALUES (?, ?, ?)`)
+	buf := make([]byte, obj.chunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			if seq == 0 && obj.contentType == "" {
+				obj.contentType = http.DetectContentType(buf[:n])
+			}
+			if _, err := this.DB.Exec(insertChunk, obj.version, seq, buf[:n]); err != nil {
+				return nil, err
+			}
+			obj.size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	old, err := this.swap(obj, string(metadataJSON))
+	if err != nil {
+		return nil, err
+	}
+	if old != "" {
+		this.deleteChunks(old)
+	}
+	return this.object(obj), nil
+}
+
+// swap points the row of obj to its version and returns the replaced version.
+// The row is only updated if its version is still the one read, so the chunks
+// of a concurrent Put are never left without a row.
+func (this *Storage) swap(obj *row, metadata string) (old string, err error) {
+	var (
+		insert = this.rebind(this.insertIgnore())
+		update = this.rebind(`UPDATE ` + this.Config.Table + ` SET version = ?, size = ?, chunk_size = ?, content_type = ?, mtime = ?, metadata = ?
+			WHERE path = ? AND version = ?`)
+		res sql.Result
+	)
+	for {
+		err = this.DB.QueryRow(this.rebind(`SELECT version FROM `+this.Config.Table+` WHERE path = ?`), obj.path).Scan(&old)
+		switch err {
+		case sql.ErrNoRows:
+			old = ""
+			res, err = this.DB.Exec(insert, obj.path, obj.version, obj.size, obj.chunkSize, obj.contentType, obj.mtime.UnixNano(), metadata)
+		case nil:
+			res, err = this.DB.Exec(update, obj.version, obj.size, obj.chunkSize, obj.contentType, obj.mtime.UnixNano(), metadata, obj.path, old)
+		}
+		if err != nil {
+			return "", err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return old, err
+		}
+	}
+}
+
+// insertIgnore returns the INSERT of a row that does nothing if the path
+// already exists.
+func (this *Storage) insertIgnore() string {
+	values := ` (` + columns + `) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	if this.Config.Driver == "mysql" {
+		return `INSERT IGNORE INTO ` + this.Config.Table + values
+	}
+	return `INSERT INTO ` + this.Config.Table + values + ` ON CONFLICT (path) DO NOTHING`
+}
+
+func (this *Storage) deleteChunks(version string) error {
+	_, err := this.DB.Exec(this.rebind(`DELETE FROM `+this.Config.Table+`_chunks WHERE version = ?`), version)
+	return err
+}
+
+func newVersion() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (this *Storage) object(obj *row) *oss.Object {
+	mtime := obj.mtime
+	return &oss.Object{
+		Path:             "/" + obj.path,
+		Name:             path.Base(obj.path),
+		LastModified:     &mtime,
+		Metadata:         obj.fullMetadata(),
+		StorageInterface: this,
+	}
+}
+
+// Delete delete file
+func (this *Storage) Delete(pth string) error {
+	k := key(pth)
+	for {
+		var version string
+		err := this.DB.QueryRow(this.rebind(`SELECT version FROM `+this.Config.Table+` WHERE path = ?`), k).Scan(&version)
+		if err == sql.ErrNoRows {
+			return &os.PathError{Op: "remove", Path: pth, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			return err
+		}
+		res, err := this.DB.Exec(this.rebind(`DELETE FROM `+this.Config.Table+` WHERE path = ? AND version = ?`), k, version)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n > 0 {
+			return this.deleteChunks(version)
+		}
+		// replaced meanwhile, read the new version
+	}
+}
+
+// List list all objects under current path
+func (this *Storage) List(pth string) (objects []*oss.Object, err error) {
+	prefix := key(pth)
+	if prefix != "" {
+		prefix += "/"
+	}
+	escaped := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(prefix)
+
+	rows, err := this.DB.Query(this.rebind(`SELECT `+columns+` FROM `+this.Config.Table+` WHERE path LIKE ? ESCAPE '!' ORDER BY path`), escaped+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		obj, err := scanRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, this.object(obj))
+	}
+	return objects, rows.Err()
+}
+
+// GetEndpoint get endpoint
+func (this *Storage) GetEndpoint() *oss.Endpoint {
+	return &this.Endpoint
+}
+
+func (this *Storage) GetURL(p ...string) (url string) {
+	url = this.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (this *Storage) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = this.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+// AssetFS exports the assets stored in the table, see oss.AssetExport.
+func (this *Storage) AssetFS() (assetfs.Interface, error) {
+	return this.assets.AssetFS(this)
+}
+
+// Close removes the files of AssetFS and closes the database opened by Open.
+// The DB given to New is left open.
+func (this *Storage) Close() error {
+	err := this.assets.Close()
+	if this.closeDB {
+		if e := this.DB.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package sqlstorage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+	"github.com/ecletus/oss/tests"
+)
+
+func newTestStorage(t *testing.T, cfg *Config) *Storage {
+	cfg.DSN = filepath.Join(t.TempDir(), "oss.db")
+	storage, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.DB.Close() })
+	return storage
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newTestStorage(t, &Config{}), t)
+}
+
+func TestChunks(t *testing.T) {
+	storage := newTestStorage(t, &Config{ChunkSize: 4})
+	metadata := &oss.Metadata{ContentDisposition: "attachment", Custom: map[string]string{"k": "v"}}
+	if _, err := storage.PutWithMetadata("a/b.txt", strings.NewReader("0123456789"), metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks int
+	storage.DB.QueryRow(`SELECT COUNT(*) FROM oss_objects_chunks`).Scan(&chunks)
+	if chunks != 3 {
+		t.Errorf("expected 3 chunks, got %v", chunks)
+	}
+
+	info, notFound, err := storage.Stat("/a/b.txt")
+	m := oss.GetMetadata(info)
+	if err != nil || notFound || info.Size() != 10 || !strings.HasPrefix(m.ContentType, "text/plain") ||
+		m.ContentDisposition != "attachment" || m.Custom["k"] != "v" {
+		t.Errorf("bad stat %v %v %+v", notFound, err, m)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/a/b.txt", nil)
+	req.Header.Set("Range", "bytes=3-8")
+	storage.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "345678" || w.Header().Get("Content-Disposition") != "attachment" {
+		t.Errorf("bad response %v %q", w.Code, w.Body.String())
+	}
+
+	// replacing removes the previous chunks
+	if _, err = storage.Put("a/b.txt", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	storage.DB.QueryRow(`SELECT COUNT(*) FROM oss_objects_chunks`).Scan(&chunks)
+	if chunks != 1 {
+		t.Errorf("expected 1 chunk, got %v", chunks)
+	}
+	file, err := storage.Get("a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "new" {
+		t.Errorf("bad content %q", data)
+	}
+
+	if err = storage.Delete("a/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	storage.DB.QueryRow(`SELECT COUNT(*) FROM oss_objects_chunks`).Scan(&chunks)
+	if chunks != 0 {
+		t.Errorf("expected no chunks, got %v", chunks)
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	storage, err := Open(&Config{DSN: filepath.Join(t.TempDir(), "oss.db") + "?_busy_timeout=10000", ChunkSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := storage.Put("a.txt", strings.NewReader(fmt.Sprintf("content %v", i)))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// only the chunks of the stored version are kept
+	var version string
+	var chunks, size int
+	storage.DB.QueryRow(`SELECT version, size FROM oss_objects`).Scan(&version, &size)
+	storage.DB.QueryRow(`SELECT COUNT(*) FROM oss_objects_chunks WHERE version <> ?`, version).Scan(&chunks)
+	if size != 9 || chunks != 0 {
+		t.Errorf("bad chunks: size %v, %v orphan chunks", size, chunks)
+	}
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	storage := newTestStorage(t, &Config{})
+	if _, err := storage.Put("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	// a deleted version, an upload in progress and an interrupted one
+	old := time.Now().Add(-48 * time.Hour).UnixNano()
+	for _, query := range []string{
+		`INSERT INTO oss_objects_chunks (version, seq, data) VALUES ('deleted', 0, 'x')`,
+		`INSERT INTO oss_objects_pending (version, started) VALUES ('uploading', ` + fmt.Sprint(time.Now().UnixNano()) + `)`,
+		`INSERT INTO oss_objects_chunks (version, seq, data) VALUES ('uploading', 0, 'x')`,
+		`INSERT INTO oss_objects_pending (version, started) VALUES ('interrupted', ` + fmt.Sprint(old) + `)`,
+		`INSERT INTO oss_objects_chunks (version, seq, data) VALUES ('interrupted', 0, 'x')`,
+	} {
+		if _, err := storage.DB.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := New(storage.DB, &Config{}); err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	rows, err := storage.DB.Query(`SELECT version FROM oss_objects_chunks WHERE version IN ('deleted', 'uploading', 'interrupted')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var version string
+		rows.Scan(&version)
+		versions = append(versions, version)
+	}
+	rows.Close()
+	if strings.Join(versions, ",") != "uploading" {
+		t.Errorf("bad chunks kept %v", versions)
+	}
+	if f, err := storage.Get("a.txt"); err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+}
+
+func TestInvalidTable(t *testing.T) {
+	if _, err := Open(&Config{DSN: filepath.Join(t.TempDir(), "oss.db"), Table: "objects; DROP TABLE x"}); err == nil {
+		t.Error("expected error of invalid table name")
+	}
+	storage := newTestStorage(t, &Config{Table: "main.objects"})
+	if _, err := storage.Put("a", strings.NewReader("a")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListEscapesPrefix(t *testing.T) {
+	storage := newTestStorage(t, &Config{})
+	for _, name := range []string{"a_b/1", "axb/2", "a%/3", "a_b/c/4"} {
+		if _, err := storage.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := storage.List("a_b")
+	if err != nil || len(objects) != 2 || objects[0].Path != "/a_b/1" || objects[1].Path != "/a_b/c/4" {
+		t.Errorf("bad list %v %v", objects, err)
+	}
+}
+
+func TestFactory(t *testing.T) {
+	factory, ok := factories.Get("sql")
+	if !ok {
+		t.Fatal("sql factory not registered")
+	}
+	storage, err := factory.Factory(factories.NewContext(), map[string]interface{}{
+		"DSN": filepath.Join(t.TempDir(), "factory.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.(*Storage).DB.Close()
+	if _, err = storage.Put("a", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+}
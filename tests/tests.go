@@ -2,7 +2,10 @@ package tests
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,7 +17,7 @@ import (
 
 func TestAll(storage oss.StorageInterface, t *testing.T) {
 	randomPath := strings.Replace(time.Now().Format("20060102150506.000"), ".", "", -1)
-	fmt.Printf("testing file in %v\n", filepath.Join(storage.GetEndpoint(), randomPath))
+	fmt.Printf("testing file in %v\n", storage.GetURL(randomPath))
 
 	fileName := "/" + filepath.Join(randomPath, "sample.txt")
 	fileName2 := "/" + filepath.Join(randomPath, "sample2", "sample.txt")
@@ -94,3 +97,70 @@ func TestAll(storage oss.StorageInterface, t *testing.T) {
 		t.Errorf("Sample file 2 should no been deleted")
 	}
 }
+
+// SpoolStorage returns the objects of Get as spools, like the storages
+// downloading them.
+type SpoolStorage struct {
+	oss.StorageInterface
+}
+
+func (this SpoolStorage) Get(pth string) (*os.File, error) {
+	src, err := this.StorageInterface.Get(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	file, err := oss.NewSpool()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(file, src); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	return file, err
+}
+
+// TestServeHTTP checks the responses of storage to the GET, HEAD, range and
+// conditional requests of the object at the escaped urlPath, which holds
+// content of at least 3 bytes.
+func TestServeHTTP(storage oss.StorageInterface, urlPath, content string, t *testing.T) {
+	serve := func(method string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, urlPath, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		storage.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET")
+	if w.Code != http.StatusOK || w.Body.String() != content {
+		t.Errorf("GET %v should return the content, but got %v %q", urlPath, w.Code, w.Body.String())
+	}
+
+	if etag := w.Header().Get("ETag"); etag != "" {
+		if w = serve("GET", "If-None-Match", etag); w.Code != http.StatusNotModified {
+			t.Errorf("GET %v with If-None-Match should return 304, but got %v", urlPath, w.Code)
+		}
+	}
+
+	if w = serve("HEAD"); w.Code != http.StatusOK || w.Body.Len() != 0 ||
+		w.Header().Get("Content-Length") != fmt.Sprint(len(content)) {
+		t.Errorf("HEAD %v should return the length only, but got %v %v %q", urlPath, w.Code, w.Header(), w.Body.String())
+	}
+
+	if w = serve("GET", "Range", "bytes=1-2"); w.Code != http.StatusPartialContent || w.Body.String() != content[1:3] {
+		t.Errorf("GET %v with Range should return the range, but got %v %q", urlPath, w.Code, w.Body.String())
+	}
+
+	if w = serve("POST"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST %v should return 405, but got %v", urlPath, w.Code)
+	}
+
+	req := httptest.NewRequest("GET", urlPath+".missing", nil)
+	w = httptest.NewRecorder()
+	if storage.ServeHTTP(w, req); w.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing object should return 404, but got %v", w.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package webdav
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// authenticator answers the Basic and Digest challenges of the server.
+type authenticator struct {
+	username, password string
+
+	mu        sync.Mutex
+	scheme    string // "", "basic" or "digest"
+	challenge map[string]string
+	nc        int
+}
+
+// update reads the challenge of a 401 response. It returns false if the
+// challenge can't be answered.
+func (a *authenticator) update(res *http.Response) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, h := range res.Header.Values("WWW-Authenticate") {
+		scheme, params := parseChallenge(h)
+		switch scheme {
+		case "digest":
+			if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+				continue
+			}
+			if qop := params["qop"]; qop != "" && !hasToken(qop, "auth") {
+				continue
+			}
+			a.scheme, a.challenge, a.nc = scheme, params, 0
+			return true
+		case "basic":
+			if a.scheme != "digest" {
+				a.scheme, a.challenge = scheme, params
+			}
+		}
+	}
+	return a.scheme != ""
+}
+
+// authorize sets the Authorization header of req.
+func (a *authenticator) authorize(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch a.scheme {
+	case "basic":
+		req.SetBasicAuth(a.username, a.password)
+	case "digest":
+		a.nc++
+		var (
+			c      = a.challenge
+			uri    = req.URL.RequestURI()
+			nc     = fmt.Sprintf("%08x", a.nc)
+			cnonce = newCnonce()
+			ha1    = md5hex(a.username + ":" + c["realm"] + ":" + a.password)
+			ha2    = md5hex(req.Method + ":" + uri)
+		)
+
+		auth := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=MD5`,
+			a.username, c["realm"], c["nonce"], uri)
+		if c["qop"] != "" {
+			auth += fmt.Sprintf(`, qop=auth, nc=%s, cnonce=%q, response=%q`,
+				nc, cnonce, md5hex(ha1+":"+c["nonce"]+":"+nc+":"+cnonce+":auth:"+ha2))
+		} else {
+			auth += fmt.Sprintf(`, response=%q`, md5hex(ha1+":"+c["nonce"]+":"+ha2))
+		}
+		if c["opaque"] != "" {
+			auth += fmt.Sprintf(`, opaque=%q`, c["opaque"])
+		}
+		req.Header.Set("Authorization", auth)
+	}
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func newCnonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseChallenge parses a WWW-Authenticate header value into its lower case
+// scheme and parameters.
+func parseChallenge(h string) (scheme string, params map[string]string) {
+	h = strings.TrimSpace(h)
+	i := strings.IndexByte(h, ' ')
+	if i < 0 {
+		return strings.ToLower(h), map[string]string{}
+	}
+	scheme, h = strings.ToLower(h[:i]), h[i+1:]
+	params = map[string]string{}
+
+	for h != "" {
+		h = strings.TrimLeft(h, " ,")
+		eq := strings.IndexByte(h, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(h[:eq]))
+		h = strings.TrimSpace(h[eq+1:])
+
+		var value string
+		if strings.HasPrefix(h, `"`) {
+			var b strings.Builder
+			j := 1
+			for ; j < len(h) && h[j] != '"'; j++ {
+				if h[j] == '\\' && j+1 < len(h) {
+					j++
+				}
+				b.WriteByte(h[j])
+			}
+			if j < len(h) {
+				j++
+			}
+			value, h = b.String(), h[j:]
+		} else {
+			end := strings.IndexByte(h, ',')
+			if end < 0 {
+				end = len(h)
+			}
+			value, h = strings.TrimSpace(h[:end]), h[end:]
+		}
+		params[key] = value
+	}
+	return
+}
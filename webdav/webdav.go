@@ -0,0 +1,482 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ecletus/helpers"
+	"github.com/moisespsena-go/assetfs"
+
+	"github.com/ecletus/oss"
+	"github.com/ecletus/oss/factories"
+	"github.com/ecletus/oss/internal/httpproxy"
+)
+
+func init() {
+	factories.Registry("webdav", factories.StorageFactoryFunc(func(ctx *factories.Context, config map[string]interface{}) (storage oss.StorageInterface, err error) {
+		var cfg Config
+		if err = helpers.ParseMap(config, &cfg); err != nil {
+			return nil, err
+		}
+		if ctx.Var != nil {
+			ctx.Var.FormatPtr(&cfg.URL, &cfg.Username, &cfg.Endpoint.Path, &cfg.Endpoint.Host)
+		}
+		return New(&cfg)
+	}))
+}
+
+// Config WebDAV client config
+type Config struct {
+	// Root collection URL, e.g. https://cloud.example.com/remote.php/dav/files/user.
+	URL      string
+	Username string
+	Password string
+	// Public URL of the files. Default is URL.
+	Endpoint oss.Endpoint
+}
+
+// Client WebDAV storage. It answers Basic and Digest challenges.
+type Client struct {
+	Config     *Config
+	HTTPClient *http.Client
+
+	base     *url.URL
+	basePath string
+	auth     *authenticator
+}
+
+// New initialize WebDAV storage
+func New(config *Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("webdav: invalid URL %q", config.URL)
+	}
+	if config.Endpoint.Host == "" && config.Endpoint.Path == "" {
+		config.Endpoint = oss.Endpoint{Scheme: base.Scheme, Host: base.Host, Path: base.Path}
+	}
+
+	client := &Client{
+		Config:     config,
+		HTTPClient: http.DefaultClient,
+		base:       base,
+		basePath:   strings.Trim(base.Path, "/"),
+	}
+	if config.Username != "" {
+		client.auth = &authenticator{username: config.Username, password: config.Password}
+	}
+	return client, nil
+}
+
+// Error WebDAV request error
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("webdav: %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// ToRelativePath returns the path relative to the root collection
+func (client *Client) ToRelativePath(pth string) string {
+	return strings.TrimPrefix(path.Clean("/"+pth), "/")
+}
+
+func (client *Client) url(pth string) string {
+	u := *client.base
+	u.Path = client.base.Path + "/" + client.ToRelativePath(pth)
+	u.RawPath = ""
+	return u.String()
+}
+
+// do sends the request, answering the authentication challenge. Responses
+// with status >= 400 are returned as *Error.
+func (client *Client) do(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.GetBody != nil
+	if client.auth != nil {
+		client.auth.authorize(req)
+	}
+
+	res, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && client.auth != nil && replayable && client.auth.update(res) {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		client.auth.authorize(retry)
+		if res, err = client.HTTPClient.Do(retry); err != nil {
+			return nil, err
+		}
+	}
+
+	if res.StatusCode >= 400 {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		return nil, &Error{req.Method, req.URL.Path, res.StatusCode}
+	}
+	return res, nil
+}
+
+func (client *Client) call(method, pth string, body io.Reader, header http.Header) error {
+	req, err := http.NewRequest(method, client.url(pth), body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := client.do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return res.Body.Close()
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getcontenttype/><d:getetag/></d:prop></d:propfind>`
+
+type resource struct {
+	Path          string
+	IsCollection  bool
+	ContentLength int64
+	LastModified  time.Time
+	ContentType   string
+	ETag          string
+}
+
+// propfind returns the resources of PROPFIND with depth "0" or "1".
+func (client *Client) propfind(pth, depth string) ([]*resource, error) {
+	req, err := http.NewRequest("PROPFIND", client.url(pth), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	res, err := client.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var ms struct {
+		Responses []struct {
+			Href      string `xml:"DAV: href"`
+			Propstats []struct {
+				Status string `xml:"DAV: status"`
+				Prop   struct {
+					ResourceType struct {
+						Collection *struct{} `xml:"DAV: collection"`
+					} `xml:"DAV: resourcetype"`
+					ContentLength int64  `xml:"DAV: getcontentlength"`
+					LastModified  string `xml:"DAV: getlastmodified"`
+					ContentType   string `xml:"DAV: getcontenttype"`
+					ETag          string `xml:"DAV: getetag"`
+				} `xml:"DAV: prop"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err = xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: PROPFIND %s: %v", pth, err)
+	}
+
+	var resources []*resource
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, fmt.Errorf("webdav: PROPFIND %s: bad href %q", pth, r.Href)
+		}
+		rel := strings.Trim(href.Path, "/")
+		if rel != client.basePath && !strings.HasPrefix(rel, client.basePath+"/") && client.basePath != "" {
+			continue
+		}
+		rel = strings.TrimPrefix(strings.TrimPrefix(rel, client.basePath), "/")
+
+		rsc := &resource{Path: "/" + rel}
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			p := ps.Prop
+			rsc.IsCollection = p.ResourceType.Collection != nil
+			rsc.ContentLength = p.ContentLength
+			rsc.LastModified, _ = http.ParseTime(p.LastModified)
+			rsc.ContentType = p.ContentType
+			rsc.ETag = p.ETag
+		}
+		resources = append(resources, rsc)
+	}
+	return resources, nil
+}
+
+// MkdirAll creates the collection and its missing parents with MKCOL.
+func (client *Client) MkdirAll(pth string) error {
+	pth = client.ToRelativePath(pth)
+	if pth == "" {
+		return nil
+	}
+
+	if _, err := client.propfind(pth, "0"); err == nil {
+		return nil
+	} else if !IsNotFound(err) {
+		return err
+	}
+
+	if err := client.MkdirAll(path.Dir(pth)); err != nil {
+		return err
+	}
+	err := client.call("MKCOL", pth+"/", nil, nil)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusMethodNotAllowed {
+		// created concurrently
+		return nil
+	}
+	return err
+}
+
+// ServeHTTP streams the file, answering the authentication challenge.
+func (client *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	httpproxy.Serve(w, r, func(method string) (*http.Request, error) {
+		return http.NewRequest(method, client.url(r.URL.Path), nil)
+	}, client.do, IsNotFound)
+}
+
+// Stat receive file stat by path
+func (client *Client) Stat(pth string) (info os.FileInfo, notFound bool, err error) {
+	resources, err := client.propfind(pth, "0")
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	if len(resources) == 0 {
+		return nil, true, nil
+	}
+	return &fileInfo{resources[0]}, false, nil
+}
+
+// Get receive file with given path
+func (client *Client) Get(pth string) (file *os.File, err error) {
+	req, err := http.NewRequest(http.MethodGet, client.url(pth), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.do(req)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, &os.PathError{Op: "open", Path: pth, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if file, err = oss.NewSpool(); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(file, res.Body); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// Put store a reader into given path, creating the missing parent
+// collections.
+func (client *Client) Put(pth string, reader io.Reader) (*oss.Object, error) {
+	rel := client.ToRelativePath(pth)
+	if err := client.MkdirAll(path.Dir(rel)); err != nil {
+		return nil, err
+	}
+
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		// many servers reject chunked uploads and a 401 challenge needs the
+		// body again, so it's spooled to send its length and replay it
+		file, err := ioutil.TempFile("", "webdav")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		os.Remove(file.Name())
+		if _, err = io.Copy(file, reader); err != nil {
+			return nil, err
+		}
+		seeker = file
+	}
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, client.url(rel), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		if size == 0 {
+			return http.NoBody, nil
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(seeker), nil
+	}
+	if req.Body, err = req.GetBody(); err != nil {
+		return nil, err
+	}
+	if contentType := mime.TypeByExtension(path.Ext(rel)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := client.do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	now := time.Now()
+	return &oss.Object{
+		Path:             pth,
+		Name:             path.Base(rel),
+		LastModified:     &now,
+		StorageInterface: client,
+	}, nil
+}
+
+// Delete delete file
+func (client *Client) Delete(pth string) error {
+	err := client.call(http.MethodDelete, pth, nil, nil)
+	if IsNotFound(err) {
+		return &os.PathError{Op: "remove", Path: pth, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+// Move moves the file or collection from to, replacing it.
+func (client *Client) Move(from, to string) error {
+	return client.transfer("MOVE", from, to)
+}
+
+// Copy copies the file or collection from to, replacing it.
+func (client *Client) Copy(from, to string) error {
+	return client.transfer("COPY", from, to)
+}
+
+func (client *Client) transfer(method, from, to string) error {
+	if err := client.MkdirAll(path.Dir(client.ToRelativePath(to))); err != nil {
+		return err
+	}
+	return client.call(method, from, nil, http.Header{
+		"Destination": {client.url(to)},
+		"Overwrite":   {"T"},
+	})
+}
+
+// List list all files under current path, walking the collections with
+// PROPFIND of depth 1 since servers usually refuse depth infinity.
+func (client *Client) List(pth string) (objects []*oss.Object, err error) {
+	self := "/" + client.ToRelativePath(pth)
+	resources, err := client.propfind(self, "1")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rsc := range resources {
+		if strings.TrimSuffix(rsc.Path, "/") == strings.TrimSuffix(self, "/") {
+			continue
+		}
+		if rsc.IsCollection {
+			children, err := client.List(rsc.Path)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, children...)
+			continue
+		}
+
+		modTime := rsc.LastModified
+		objects = append(objects, &oss.Object{
+			Path:             rsc.Path,
+			Name:             path.Base(rsc.Path),
+			LastModified:     &modTime,
+			StorageInterface: client,
+		})
+	}
+	return objects, nil
+}
+
+// GetEndpoint get endpoint
+func (client *Client) GetEndpoint() *oss.Endpoint {
+	return &client.Config.Endpoint
+}
+
+func (client *Client) GetURL(p ...string) (url string) {
+	url = client.Config.Endpoint.URL()
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) GetDynamicURL(scheme, host string, p ...string) (url string) {
+	url = client.Config.Endpoint.DinamicURL(scheme, host)
+	if len(p) > 0 {
+		url += "/" + strings.TrimPrefix(strings.Join(p, "/"), "/")
+	}
+	return
+}
+
+func (client *Client) AssetFS() (assetfs.Interface, error) {
+	return nil, oss.ErrAssetFsUnavailable
+}
+
+type fileInfo struct {
+	rsc *resource
+}
+
+func (fi *fileInfo) Name() string       { return path.Base(fi.rsc.Path) }
+func (fi *fileInfo) Size() int64        { return fi.rsc.ContentLength }
+func (fi *fileInfo) ModTime() time.Time { return fi.rsc.LastModified }
+func (fi *fileInfo) IsDir() bool        { return fi.rsc.IsCollection }
+func (*fileInfo) Sys() interface{}      { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.rsc.IsCollection {
+		return os.ModeDir | 0500
+	}
+	return 0400
+}
+
+// ETag returns the entity tag of the file.
+func (fi *fileInfo) ETag() string { return fi.rsc.ETag }
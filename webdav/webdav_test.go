@@ -0,0 +1,160 @@
+package webdav
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/ecletus/oss/tests"
+)
+
+const (
+	prefix   = "/dav/files/user"
+	username = "user"
+	password = "secret"
+	realm    = "test"
+	nonce    = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+)
+
+// newTestServer starts a golang.org/x/net/webdav server requiring Basic or
+// Digest authentication. Like Nextcloud, it answers OPTIONS without a
+// challenge and rejects chunked uploads.
+func newTestServer(t *testing.T, digest bool) *httptest.Server {
+	dav := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, MKCOL, COPY, MOVE")
+			return
+		}
+		if r.Method == http.MethodPut && r.ContentLength < 0 {
+			http.Error(w, "length required", http.StatusLengthRequired)
+			return
+		}
+		if digest {
+			if !checkDigest(r) {
+				w.Header().Set("WWW-Authenticate", `Digest realm="`+realm+`", qop="auth", nonce="`+nonce+`", opaque="5ccc069c"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func checkDigest(r *http.Request) bool {
+	scheme, params := parseChallenge(r.Header.Get("Authorization"))
+	if scheme != "digest" || params["username"] != username || params["nonce"] != nonce ||
+		params["uri"] != r.URL.RequestURI() || params["opaque"] != "5ccc069c" {
+		return false
+	}
+	ha1 := md5hex(username + ":" + realm + ":" + password)
+	ha2 := md5hex(r.Method + ":" + params["uri"])
+	return params["response"] == md5hex(ha1+":"+nonce+":"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2)
+}
+
+func newTestClient(t *testing.T, digest bool) *Client {
+	client, err := New(&Config{URL: newTestServer(t, digest).URL + prefix, Username: username, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newTestClient(t, false), t)
+}
+
+func TestDigestAuth(t *testing.T) {
+	tests.TestAll(newTestClient(t, true), t)
+
+	client := newTestClient(t, true)
+	client.auth.password = "wrong"
+	if _, _, err := client.Stat("a"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+}
+
+func TestStreamingPut(t *testing.T) {
+	client := newTestClient(t, true)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write([]byte("streamed"))
+		writer.Close()
+	}()
+	if _, err := client.Put("a/b/stream.txt", reader); err != nil {
+		t.Fatal(err)
+	}
+	if info, _, err := client.Stat("a/b/stream.txt"); err != nil || info.Size() != 8 {
+		t.Errorf("bad stat %v %v", info, err)
+	}
+}
+
+func TestMoveCopy(t *testing.T) {
+	client := newTestClient(t, false)
+	if _, err := client.Put("a/b/c.txt", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	if info, notFound, err := client.Stat("a/b"); err != nil || notFound || !info.IsDir() {
+		t.Fatalf("parent collection not created %v %v", notFound, err)
+	}
+
+	if err := client.Copy("a/b/c.txt", "x/y/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Move("a/b/c.txt", "a/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, notFound, _ := client.Stat("a/b/c.txt"); !notFound {
+		t.Errorf("moved file should not exist")
+	}
+
+	objects, err := client.List("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, o := range objects {
+		paths = append(paths, o.Path)
+	}
+	// the server lists the collections in no particular order
+	sort.Strings(paths)
+	if got := strings.Join(paths, ","); got != "/a/moved.txt,/x/y/c.txt" {
+		t.Errorf("bad list %v", got)
+	}
+
+	tests.TestServeHTTP(client, "/x/y/c.txt", "content", t)
+
+	file, err := client.Get("a/moved.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, _ := ioutil.ReadAll(file); string(data) != "content" {
+		t.Errorf("bad content %q", data)
+	}
+
+	if err = client.Delete("a/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Delete("a/moved.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}